@@ -0,0 +1,220 @@
+// Package alerts notifies webhooks when an exchange rate crosses a threshold
+package alerts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Direction is the direction a rate has to cross a threshold in
+type Direction int
+
+const (
+	// Above triggers when the rate rises to or above the threshold
+	Above Direction = iota
+	// Below triggers when the rate falls to or below the threshold
+	Below
+)
+
+func (d Direction) String() string {
+	if d == Below {
+		return "below"
+	}
+	return "above"
+}
+
+// Rule is an alert for a currency pair
+type Rule struct {
+	ID            string
+	Base          string
+	Destination   string
+	Direction     Direction
+	Threshold     float64
+	Cooldown      time.Duration
+	WebhookURL    string
+	LastTriggered time.Time
+
+	// last is the rate at the previous evaluation, a rule triggers when the
+	// rate crosses the threshold between two evaluations
+	last float64
+}
+
+// crossed returns true when the move from the last rate to rate crosses the threshold
+func (r *Rule) crossed(rate float64) bool {
+	if r.Direction == Below {
+		return r.last > r.Threshold && rate <= r.Threshold
+	}
+	return r.last < r.Threshold && rate >= r.Threshold
+}
+
+// ErrRuleNotFound is returned when there is no rule with the given ID
+var ErrRuleNotFound = fmt.Errorf("alert rule not found")
+
+// ErrNoWebhook is returned when a rule has no webhook URL and there is no default
+var ErrNoWebhook = fmt.Errorf("alert rule has no webhook URL and no default is configured")
+
+// RateSource provides the rates the rules are evaluated against, it is implemented by data.ExchangeRates
+type RateSource interface {
+	GetRate(base, dest string) (float64, error)
+	MonitorRates() <-chan struct{}
+}
+
+// Alerts holds the alert rules and evaluates them on every rate update
+type Alerts struct {
+	log        hclog.Logger
+	rates      RateSource
+	notifier   Notifier
+	defaultURL string
+
+	mu     sync.Mutex
+	rules  map[string]*Rule
+	lastID int
+}
+
+// New creates Alerts sending notifications with n, defaultURL is used for
+// rules without a webhook URL and may be empty
+func New(l hclog.Logger, rates RateSource, n Notifier, defaultURL string) *Alerts {
+	return &Alerts{log: l, rates: rates, notifier: n, defaultURL: defaultURL, rules: map[string]*Rule{}}
+}
+
+// Run evaluates the rules on every rate update until ctx is done
+func (a *Alerts) Run(ctx context.Context) {
+	updates := a.rates.MonitorRates()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-updates:
+			a.Evaluate(time.Now())
+		}
+	}
+}
+
+// Create adds a rule, the ID is assigned and the current rate is the starting point for crossings
+func (a *Alerts) Create(r Rule) (Rule, error) {
+	if r.WebhookURL == "" && a.defaultURL == "" {
+		return Rule{}, ErrNoWebhook
+	}
+	rate, err := a.rates.GetRate(r.Base, r.Destination)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lastID++
+	r.ID = fmt.Sprintf("alert-%d", a.lastID)
+	r.LastTriggered = time.Time{}
+	r.last = rate
+	a.rules[r.ID] = &r
+	return r, nil
+}
+
+// List returns all rules ordered by ID
+func (a *Alerts) List() []Rule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rs := []Rule{}
+	for _, r := range a.rules {
+		rs = append(rs, *r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if len(rs[i].ID) != len(rs[j].ID) {
+			return len(rs[i].ID) < len(rs[j].ID)
+		}
+		return rs[i].ID < rs[j].ID
+	})
+	return rs
+}
+
+// Delete removes a rule and returns it
+func (a *Alerts) Delete(id string) (Rule, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r, ok := a.rules[id]
+	if !ok {
+		return Rule{}, ErrRuleNotFound
+	}
+	delete(a.rules, id)
+	return *r, nil
+}
+
+// Evaluate checks every rule against the current rates and sends a notification
+// for the ones which crossed their threshold and are not cooling down
+func (a *Alerts) Evaluate(now time.Time) {
+	a.mu.Lock()
+	ns := []*Notification{}
+	for _, r := range a.rules {
+		rate, err := a.rates.GetRate(r.Base, r.Destination)
+		if err != nil {
+			a.log.Error("Unable to get rate for alert", "id", r.ID, "error", err)
+			continue
+		}
+		previous := r.last
+		crossed := r.crossed(rate)
+		r.last = rate
+		if !crossed {
+			continue
+		}
+		if !r.LastTriggered.IsZero() && now.Sub(r.LastTriggered) < r.Cooldown {
+			a.log.Debug("Alert is cooling down", "id", r.ID)
+			continue
+		}
+		id, err := newID()
+		if err != nil {
+			// the crossing is seen again on the next evaluation
+			a.log.Error("Unable to create notification ID", "id", r.ID, "error", err)
+			r.last = previous
+			continue
+		}
+		r.LastTriggered = now
+
+		url := r.WebhookURL
+		if url == "" {
+			url = a.defaultURL
+		}
+		ns = append(ns, &Notification{
+			ID:           id,
+			AlertID:      r.ID,
+			Base:         r.Base,
+			Destination:  r.Destination,
+			Direction:    r.Direction.String(),
+			Threshold:    r.Threshold,
+			Rate:         rate,
+			PreviousRate: previous,
+			Time:         now,
+			URL:          url,
+		})
+	}
+	a.mu.Unlock()
+
+	// deliver in the background, retries must not hold up the next evaluation
+	for _, n := range ns {
+		a.log.Info("Alert triggered", "id", n.AlertID, "base", n.Base, "destination", n.Destination, "rate", n.Rate)
+		go func(n *Notification) {
+			err := a.notifier.Notify(context.Background(), n)
+			if err != nil {
+				a.log.Error("Unable to deliver alert", "id", n.AlertID, "url", n.URL, "error", err)
+			}
+		}(n)
+	}
+}
+
+// newID returns a random hex ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("unable to read random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,141 @@
+package alerts
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRates is a RateSource with a single settable rate
+type fakeRates struct {
+	mu   sync.Mutex
+	rate float64
+}
+
+func (f *fakeRates) set(r float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rate = r
+}
+
+func (f *fakeRates) GetRate(base, dest string) (float64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rate, nil
+}
+
+func (f *fakeRates) MonitorRates() <-chan struct{} {
+	return make(chan struct{})
+}
+
+// receiver is a webhook which fails the first delivery attempt
+type receiver struct {
+	*httptest.Server
+	attempts int
+	received chan Notification
+}
+
+func newReceiver(t *testing.T, secret string) *receiver {
+	r := &receiver{received: make(chan Notification, 10)}
+	mu := sync.Mutex{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if !webhook.VerifySignature([]byte(secret), body, req.Header.Get(SignatureHeader)) {
+			http.Error(rw, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		r.attempts++
+		first := r.attempts == 1
+		mu.Unlock()
+		if first {
+			http.Error(rw, "try again", http.StatusServiceUnavailable)
+			return
+		}
+
+		n := Notification{}
+		json.Unmarshal(body, &n)
+		r.received <- n
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func TestAlertDelivery(t *testing.T) {
+	rec := newReceiver(t, "s3cret")
+	rates := &fakeRates{rate: 0.88}
+	a := New(hclog.NewNullLogger(), rates, NewWebhookNotifier("s3cret").WithRetries(3, time.Millisecond), rec.URL)
+
+	r, err := a.Create(Rule{Base: "EUR", Destination: "GBP", Direction: Above, Threshold: 0.9, Cooldown: time.Hour})
+	assert.NoError(t, err)
+	assert.Equal(t, "alert-1", r.ID)
+
+	// no crossing yet
+	rates.set(0.89)
+	a.Evaluate(time.Now())
+
+	rates.set(0.91)
+	a.Evaluate(time.Now())
+
+	select {
+	case n := <-rec.received:
+		assert.Equal(t, "alert-1", n.AlertID)
+		assert.Equal(t, "above", n.Direction)
+		assert.Equal(t, 0.91, n.Rate)
+		assert.Equal(t, 0.89, n.PreviousRate)
+	case <-time.After(5 * time.Second):
+		t.Fatal("alert was not delivered")
+	}
+	assert.False(t, a.List()[0].LastTriggered.IsZero())
+
+	// crossing again within the cooldown does not trigger
+	rates.set(0.85)
+	a.Evaluate(time.Now())
+	rates.set(0.95)
+	a.Evaluate(time.Now())
+	select {
+	case <-rec.received:
+		t.Fatal("alert triggered during its cooldown")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCrossingDirections(t *testing.T) {
+	above := &Rule{Direction: Above, Threshold: 1, last: 0.9}
+	assert.True(t, above.crossed(1))
+	assert.False(t, above.crossed(0.95))
+
+	below := &Rule{Direction: Below, Threshold: 1, last: 1.1}
+	assert.True(t, below.crossed(0.99))
+	assert.False(t, below.crossed(1.2))
+
+	// already past the threshold is not a crossing
+	below.last = 0.9
+	assert.False(t, below.crossed(0.8))
+}
+
+func TestRuleManagement(t *testing.T) {
+	a := New(hclog.NewNullLogger(), &fakeRates{rate: 1}, NewWebhookNotifier(""), "")
+
+	_, err := a.Create(Rule{Base: "EUR", Destination: "GBP", Threshold: 2})
+	assert.Equal(t, ErrNoWebhook, err)
+
+	r, err := a.Create(Rule{Base: "EUR", Destination: "GBP", Threshold: 2, WebhookURL: "http://localhost/alerts"})
+	assert.NoError(t, err)
+	assert.Len(t, a.List(), 1)
+
+	_, err = a.Delete(r.ID)
+	assert.NoError(t, err)
+	_, err = a.Delete(r.ID)
+	assert.Equal(t, ErrRuleNotFound, err)
+	assert.Empty(t, a.List())
+}
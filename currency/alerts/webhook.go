@@ -0,0 +1,63 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/satoshi-u/go-microservices/webhook"
+)
+
+// Notification is the JSON body posted to a webhook when an alert is triggered
+type Notification struct {
+	// ID is unique per trigger, it is the same for retries so receivers can ignore duplicates
+	ID           string    `json:"id"`
+	AlertID      string    `json:"alert_id"`
+	Base         string    `json:"base"`
+	Destination  string    `json:"destination"`
+	Direction    string    `json:"direction"`
+	Threshold    float64   `json:"threshold"`
+	Rate         float64   `json:"rate"`
+	PreviousRate float64   `json:"previous_rate"`
+	Time         time.Time `json:"time"`
+
+	// URL is the webhook the notification is sent to
+	URL string `json:"-"`
+}
+
+// Notifier delivers notifications of triggered alerts
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// SignatureHeader is the header containing the HMAC-SHA256 of the webhook body,
+// the same header and format as the product-api event webhooks
+const SignatureHeader = webhook.SignatureHeader
+
+// WebhookNotifier posts notifications as JSON, signing the body with HMAC-SHA256
+// so receivers can verify the sender
+type WebhookNotifier struct {
+	client *webhook.Client
+}
+
+// NewWebhookNotifier creates a WebhookNotifier signing with secret
+func NewWebhookNotifier(secret string) *WebhookNotifier {
+	return &WebhookNotifier{client: webhook.NewClient(secret)}
+}
+
+// WithRetries sets the number of retries and the initial backoff between them,
+// the backoff doubles after every failed attempt
+func (w *WebhookNotifier) WithRetries(maxRetries int, backoff time.Duration) *WebhookNotifier {
+	w.client.WithRetries(maxRetries, backoff)
+	return w
+}
+
+// Notify posts the notification, retrying on network errors, 429 and 5xx responses
+func (w *WebhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return w.client.Post(ctx, n.URL, http.Header{"X-Alert-ID": {n.AlertID}}, body)
+}
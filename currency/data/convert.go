@@ -0,0 +1,115 @@
+package data
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RoundingMode is the method used to round an amount to a number of decimal places
+type RoundingMode int
+
+// Rounding modes, the names follow java.math.RoundingMode
+const (
+	RoundHalfEven RoundingMode = iota // nearest, ties to the even neighbour
+	RoundHalfUp                       // nearest, ties away from zero
+	RoundHalfDown                     // nearest, ties towards zero
+	RoundUp                           // away from zero
+	RoundDown                         // towards zero
+	RoundCeiling                      // towards positive infinity
+	RoundFloor                        // towards negative infinity
+)
+
+// decimalPattern matches plain decimal numbers, big.Rat would also accept fractions and exponents
+var decimalPattern = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+
+// ParseDecimal parses a decimal string such as "-12.34" into an exact rational number
+func ParseDecimal(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if !decimalPattern.MatchString(s) {
+		return nil, fmt.Errorf("invalid decimal amount %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal amount %q", s)
+	}
+	return r, nil
+}
+
+// FromUnitsNanos converts an amount given as units and nanos, as in google.type.Money
+func FromUnitsNanos(units int64, nanos int32) (*big.Rat, error) {
+	if nanos <= -1e9 || nanos >= 1e9 {
+		return nil, fmt.Errorf("nanos %d out of range", nanos)
+	}
+	if (units > 0 && nanos < 0) || (units < 0 && nanos > 0) {
+		return nil, fmt.Errorf("units %d and nanos %d must have the same sign", units, nanos)
+	}
+	r := new(big.Rat).SetInt64(units)
+	return r.Add(r, big.NewRat(int64(nanos), 1e9)), nil
+}
+
+// ToUnitsNanos splits an amount into units and nanos, the amount must have at most 9 decimal places
+func ToUnitsNanos(r *big.Rat) (int64, int32) {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt64(1e9))
+	n := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+	units, nanos := new(big.Int).QuoRem(n, big.NewInt(1e9), new(big.Int))
+	return units.Int64(), int32(nanos.Int64())
+}
+
+// RateToDecimal converts a floating point rate to the shortest decimal which
+// represents it, so that 1.1 is treated as 11/10 and not its binary approximation
+func RateToDecimal(rate float64) *big.Rat {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(rate, 'g', -1, 64))
+	return r
+}
+
+// Round rounds r to the given number of decimal places using the rounding mode
+func Round(r *big.Rat, places int, mode RoundingMode) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(scale))
+
+	// q is truncated towards zero, rem has the sign of the amount
+	q, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if rem.Sign() != 0 {
+		negative := scaled.Sign() < 0
+		// compare the discarded fraction with one half: 2*|rem| vs denominator
+		half := new(big.Int).Abs(rem)
+		half.Lsh(half, 1)
+		cmp := half.Cmp(scaled.Denom())
+
+		away := false
+		switch mode {
+		case RoundUp:
+			away = true
+		case RoundDown:
+			away = false
+		case RoundCeiling:
+			away = !negative
+		case RoundFloor:
+			away = negative
+		case RoundHalfUp:
+			away = cmp >= 0
+		case RoundHalfDown:
+			away = cmp > 0
+		default: // RoundHalfEven
+			away = cmp > 0 || (cmp == 0 && q.Bit(0) == 1)
+		}
+		if away {
+			if negative {
+				q.Sub(q, big.NewInt(1))
+			} else {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	return new(big.Rat).SetFrac(q, scale)
+}
+
+// Convert multiplies the amount by the rate and rounds the result to the minor
+// units of the destination currency
+func Convert(amount *big.Rat, rate float64, destination string, mode RoundingMode) *big.Rat {
+	r := new(big.Rat).Mul(amount, RateToDecimal(rate))
+	return Round(r, MinorUnits(destination), mode)
+}
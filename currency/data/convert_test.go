@@ -0,0 +1,52 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundingModes(t *testing.T) {
+	cases := []struct {
+		amount string
+		mode   RoundingMode
+		want   string
+	}{
+		{"2.345", RoundHalfEven, "2.34"},
+		{"2.355", RoundHalfEven, "2.36"},
+		{"2.345", RoundHalfUp, "2.35"},
+		{"2.345", RoundHalfDown, "2.34"},
+		{"2.341", RoundUp, "2.35"},
+		{"2.349", RoundDown, "2.34"},
+		{"-2.341", RoundCeiling, "-2.34"},
+		{"-2.341", RoundFloor, "-2.35"},
+		{"-2.345", RoundHalfUp, "-2.35"},
+	}
+	for _, c := range cases {
+		r, err := ParseDecimal(c.amount)
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, Round(r, 2, c.mode).FloatString(2), "%s mode %d", c.amount, c.mode)
+	}
+}
+
+func TestConvertUsesMinorUnits(t *testing.T) {
+	amount, _ := ParseDecimal("10")
+	assert.Equal(t, "1576", Convert(amount, 157.65, "JPY", RoundHalfEven).FloatString(0))
+	assert.Equal(t, "1577", Convert(amount, 157.65, "JPY", RoundHalfUp).FloatString(0))
+	assert.Equal(t, "8.70", Convert(amount, 0.87, "GBP", RoundHalfEven).FloatString(2))
+}
+
+func TestUnitsNanos(t *testing.T) {
+	r, err := FromUnitsNanos(-1, -750000000)
+	assert.NoError(t, err)
+	assert.Equal(t, "-1.75", r.FloatString(2))
+
+	units, nanos := ToUnitsNanos(r)
+	assert.Equal(t, int64(-1), units)
+	assert.Equal(t, int32(-750000000), nanos)
+
+	_, err = FromUnitsNanos(1, -5)
+	assert.Error(t, err)
+	_, err = ParseDecimal("1/3")
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package data
+
+import "regexp"
+
+// CurrencyInfo describes an ISO 4217 currency
+type CurrencyInfo struct {
+	Code       string
+	Name       string
+	MinorUnits int
+}
+
+// currencies are the ISO 4217 currencies which rate providers are known to quote, codes which
+// are not listed here are still accepted when a provider has a rate for them
+var currencies = map[string]CurrencyInfo{
+	"AUD": {"AUD", "Australian Dollar", 2},
+	"BGN": {"BGN", "Bulgarian Lev", 2},
+	"BRL": {"BRL", "Brazilian Real", 2},
+	"CAD": {"CAD", "Canadian Dollar", 2},
+	"CHF": {"CHF", "Swiss Franc", 2},
+	"CNY": {"CNY", "Chinese Yuan Renminbi", 2},
+	"CZK": {"CZK", "Czech Koruna", 2},
+	"DKK": {"DKK", "Danish Krone", 2},
+	"EUR": {"EUR", "Euro", 2},
+	"GBP": {"GBP", "Pound Sterling", 2},
+	"HKD": {"HKD", "Hong Kong Dollar", 2},
+	"HRK": {"HRK", "Croatian Kuna", 2},
+	"HUF": {"HUF", "Hungarian Forint", 2},
+	"IDR": {"IDR", "Indonesian Rupiah", 2},
+	"ILS": {"ILS", "Israeli New Shekel", 2},
+	"INR": {"INR", "Indian Rupee", 2},
+	"ISK": {"ISK", "Iceland Krona", 0},
+	"JPY": {"JPY", "Japanese Yen", 0},
+	"KRW": {"KRW", "South Korean Won", 0},
+	"MXN": {"MXN", "Mexican Peso", 2},
+	"MYR": {"MYR", "Malaysian Ringgit", 2},
+	"NOK": {"NOK", "Norwegian Krone", 2},
+	"NZD": {"NZD", "New Zealand Dollar", 2},
+	"PHP": {"PHP", "Philippine Peso", 2},
+	"PLN": {"PLN", "Polish Zloty", 2},
+	"RON": {"RON", "Romanian Leu", 2},
+	"RUB": {"RUB", "Russian Ruble", 2},
+	"SEK": {"SEK", "Swedish Krona", 2},
+	"SGD": {"SGD", "Singapore Dollar", 2},
+	"THB": {"THB", "Thai Baht", 2},
+	"TRY": {"TRY", "Turkish Lira", 2},
+	"USD": {"USD", "US Dollar", 2},
+	"ZAR": {"ZAR", "South African Rand", 2},
+}
+
+// codePattern matches ISO 4217 alphabetic codes
+var codePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidCode returns true for strings shaped like an ISO 4217 code, it does not check the code exists
+func ValidCode(code string) bool {
+	return codePattern.MatchString(code)
+}
+
+// Currency returns the description of a currency, unknown codes get
+// the code as name and 2 minor units
+func Currency(code string) CurrencyInfo {
+	if ci, ok := currencies[code]; ok {
+		return ci
+	}
+	return CurrencyInfo{Code: code, Name: code, MinorUnits: 2}
+}
+
+// MinorUnits returns the number of decimal places used for amounts of the currency
+func MinorUnits(currency string) int {
+	return Currency(currency).MinorUnits
+}
+
+// HasCurrency returns true when there is a rate for the currency
+func (e *ExchangeRates) HasCurrency(code string) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	_, ok := e.rates[code]
+	return ok
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Snapshot is the set of rates against a base currency at a point in time
+type Snapshot struct {
+	Time time.Time
+	// Base is the currency the rates are quoted against, its own rate is 1
+	Base  string
+	Rates map[string]float64
+}
+
+// Rebase returns the snapshot quoted against pivot, every rate is triangulated
+// through the pivot's rate in the snapshot: rate(pivot->c) = rate(base->c) / rate(base->pivot)
+func (s *Snapshot) Rebase(pivot string) (*Snapshot, error) {
+	if s.Base == pivot {
+		return s, nil
+	}
+	pr, ok := s.Rates[pivot]
+	if !ok || pr == 0 {
+		return nil, fmt.Errorf("rates quoted against %s on %s have no %s rate to triangulate through", s.Base, s.Time.Format(DateFormat), pivot)
+	}
+
+	rates := make(map[string]float64, len(s.Rates))
+	for c, r := range s.Rates {
+		rates[c] = r / pr
+	}
+	rates[pivot] = 1
+	return &Snapshot{Time: s.Time, Base: pivot, Rates: rates}, nil
+}
+
+// rate returns the rate between two currencies in the snapshot
+func (s *Snapshot) rate(base, dest string) (float64, error) {
+	br, ok := s.Rates[base]
+	if !ok {
+		return 0, fmt.Errorf("rate not found for currency %s on %s", base, s.Time.Format(DateFormat))
+	}
+	dr, ok := s.Rates[dest]
+	if !ok {
+		return 0, fmt.Errorf("rate not found for currency %s on %s", dest, s.Time.Format(DateFormat))
+	}
+	return dr / br, nil
+}
+
+// RatePoint is the rate between two currencies at a point in time
+type RatePoint struct {
+	Time time.Time
+	Rate float64
+}
+
+// DateFormat is the format of dates in the ECB documents and in the history API
+const DateFormat = "2006-01-02"
+
+// ErrNoHistory is returned when there is no snapshot at or before the requested time
+var ErrNoHistory = fmt.Errorf("no rates available for the requested date")
+
+// History is a time ordered series of rate snapshots. Daily reference rates are
+// kept forever, intraday refreshes from the provider are kept up to a limit.
+// Simulated changes are never recorded, they would be served as historical rates.
+type History struct {
+	mu sync.RWMutex
+	// daily reference rates, one per day, sorted by time
+	daily []*Snapshot
+	// intraday snapshots recorded when the provider rates are refreshed, sorted by time
+	intraday    []*Snapshot
+	maxIntraday int
+}
+
+// NewHistory creates an empty History keeping at most maxIntraday intraday snapshots
+func NewHistory(maxIntraday int) *History {
+	return &History{maxIntraday: maxIntraday}
+}
+
+// AddDaily stores the reference rates for a day, replacing any already stored for the same day
+func (h *History) AddDaily(s *Snapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s = &Snapshot{Time: s.Time.UTC().Truncate(24 * time.Hour), Base: s.Base, Rates: copyRates(s.Rates)}
+	i := sort.Search(len(h.daily), func(i int) bool { return !h.daily[i].Time.Before(s.Time) })
+	if i < len(h.daily) && h.daily[i].Time.Equal(s.Time) {
+		h.daily[i] = s
+		return
+	}
+	h.daily = append(h.daily, nil)
+	copy(h.daily[i+1:], h.daily[i:])
+	h.daily[i] = s
+}
+
+// Record stores an intraday snapshot of rates quoted against base, the oldest
+// intraday snapshot is dropped when over the limit
+func (h *History) Record(t time.Time, base string, rates map[string]float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.intraday = append(h.intraday, &Snapshot{Time: t.UTC(), Base: base, Rates: copyRates(rates)})
+	if len(h.intraday) > h.maxIntraday {
+		h.intraday = h.intraday[len(h.intraday)-h.maxIntraday:]
+	}
+}
+
+// At returns the most recent snapshot at or before t
+func (h *History) At(t time.Time) (*Snapshot, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var found *Snapshot
+	for _, ss := range [][]*Snapshot{h.daily, h.intraday} {
+		i := sort.Search(len(ss), func(i int) bool { return ss[i].Time.After(t) })
+		if i > 0 && (found == nil || ss[i-1].Time.After(found.Time)) {
+			found = ss[i-1]
+		}
+	}
+	if found == nil {
+		return nil, ErrNoHistory
+	}
+	return found, nil
+}
+
+// Between returns all snapshots from start to end inclusive, in time order
+func (h *History) Between(start, end time.Time) []*Snapshot {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ret := []*Snapshot{}
+	for _, ss := range [][]*Snapshot{h.daily, h.intraday} {
+		for _, s := range ss {
+			if !s.Time.Before(start) && !s.Time.After(end) {
+				ret = append(ret, s)
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Time.Before(ret[j].Time) })
+	return ret
+}
+
+// Len returns the number of daily and intraday snapshots
+func (h *History) Len() (int, int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.daily), len(h.intraday)
+}
+
+func copyRates(rates map[string]float64) map[string]float64 {
+	c := make(map[string]float64, len(rates))
+	for k, v := range rates {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,59 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func day(s string) time.Time {
+	t, _ := time.Parse(DateFormat, s)
+	return t
+}
+
+func TestHistoryAtUsesLastKnownRates(t *testing.T) {
+	h := NewHistory(10)
+	h.AddDaily(&Snapshot{Time: day("2023-03-09"), Rates: map[string]float64{"EUR": 1, "GBP": 0.88}})
+	h.AddDaily(&Snapshot{Time: day("2023-03-10"), Rates: map[string]float64{"EUR": 1, "GBP": 0.89}})
+
+	// a Sunday falls back to the Friday rates
+	s, err := h.At(day("2023-03-12"))
+	assert.NoError(t, err)
+	assert.Equal(t, day("2023-03-10"), s.Time)
+
+	_, err = h.At(day("2023-03-01"))
+	assert.Equal(t, ErrNoHistory, err)
+}
+
+func TestHistoryBetweenMergesIntraday(t *testing.T) {
+	h := NewHistory(1)
+	h.AddDaily(&Snapshot{Time: day("2023-03-10"), Rates: map[string]float64{"EUR": 1, "GBP": 0.89}})
+	h.Record(day("2023-03-10").Add(time.Hour), "EUR", map[string]float64{"EUR": 1, "GBP": 0.9})
+	h.Record(day("2023-03-10").Add(2*time.Hour), "EUR", map[string]float64{"EUR": 1, "GBP": 0.91})
+
+	daily, intraday := h.Len()
+	assert.Equal(t, 1, daily)
+	assert.Equal(t, 1, intraday)
+
+	ss := h.Between(day("2023-03-10"), day("2023-03-11"))
+	assert.Len(t, ss, 2)
+	assert.Equal(t, 0.91, ss[1].Rates["GBP"])
+	assert.Equal(t, "EUR", ss[1].Base)
+}
+
+func TestSnapshotRebase(t *testing.T) {
+	s := &Snapshot{Time: day("2023-03-10"), Base: "EUR", Rates: map[string]float64{"EUR": 1, "USD": 1.25, "GBP": 0.5}}
+
+	us, err := s.Rebase("USD")
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", us.Base)
+	assert.Equal(t, map[string]float64{"EUR": 0.8, "USD": 1, "GBP": 0.4}, us.Rates)
+
+	same, err := s.Rebase("EUR")
+	assert.NoError(t, err)
+	assert.Same(t, s, same)
+
+	_, err = s.Rebase("INR")
+	assert.Error(t, err)
+}
@@ -0,0 +1,304 @@
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// RateProvider is a source of reference rates, each Snapshot names the currency its rates are quoted against
+type RateProvider interface {
+	// Name identifies the provider in logs
+	Name() string
+	// Latest returns the most recent rates known to the provider
+	Latest() (*Snapshot, error)
+	// History returns every day of rates known to the provider, oldest first
+	History() ([]*Snapshot, error)
+}
+
+// ECBProvider fetches the reference rates published by the European Central Bank
+type ECBProvider struct {
+	client     *http.Client
+	dailyURL   string
+	historyURL string
+}
+
+// NewECBProvider creates an ECBProvider, historyURL may be empty when no
+// history is needed. A nil client uses http.DefaultClient.
+func NewECBProvider(client *http.Client, dailyURL, historyURL string) *ECBProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &ECBProvider{client: client, dailyURL: dailyURL, historyURL: historyURL}
+}
+
+// Name returns "ecb"
+func (p *ECBProvider) Name() string {
+	return "ecb"
+}
+
+// Latest fetches the daily document
+func (p *ECBProvider) Latest() (*Snapshot, error) {
+	days, err := p.fetch(p.dailyURL)
+	if err != nil {
+		return nil, err
+	}
+	return last(days)
+}
+
+// History fetches the history document, or the daily document when there is none
+func (p *ECBProvider) History() ([]*Snapshot, error) {
+	if p.historyURL == "" {
+		return p.fetch(p.dailyURL)
+	}
+	return p.fetch(p.historyURL)
+}
+
+// fetch gets and decodes an ECB rates document
+func (p *ECBProvider) fetch(url string) ([]*Snapshot, error) {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("expected status_code 200, got %d", resp.StatusCode)
+	}
+	return decodeECB(resp.Body)
+}
+
+// FileProvider reads rates from a file on disk, the format is chosen by extension:
+//
+//	.xml  an ECB daily or history document
+//	.csv  the ECB history CSV, a Date column then one column per currency
+//	.json a single {"date": "2023-03-10", "rates": {"USD": 1.067}} object or a list of them,
+//	      with an optional "base": "USD" when the rates are not quoted against EUR
+//
+// The file is read on every call so it can be replaced while the service is running.
+type FileProvider struct {
+	path string
+}
+
+// NewFileProvider creates a FileProvider, it fails for unsupported extensions
+func NewFileProvider(path string) (*FileProvider, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml", ".csv", ".json":
+		return &FileProvider{path: path}, nil
+	}
+	return nil, fmt.Errorf("unsupported rates file %s, expected .xml, .csv or .json", path)
+}
+
+// Name returns "file"
+func (p *FileProvider) Name() string {
+	return "file"
+}
+
+// Latest returns the most recent day in the file
+func (p *FileProvider) Latest() (*Snapshot, error) {
+	days, err := p.History()
+	if err != nil {
+		return nil, err
+	}
+	return last(days)
+}
+
+// History returns every day in the file
+func (p *FileProvider) History() ([]*Snapshot, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".csv":
+		return decodeCSV(f)
+	case ".json":
+		return decodeJSON(f)
+	default:
+		return decodeECB(f)
+	}
+}
+
+// CompositeProvider asks its providers in priority order and returns the first successful result
+type CompositeProvider struct {
+	log       hclog.Logger
+	providers []RateProvider
+}
+
+// NewCompositeProvider creates a CompositeProvider, the first provider has the highest priority
+func NewCompositeProvider(l hclog.Logger, providers ...RateProvider) *CompositeProvider {
+	return &CompositeProvider{log: l, providers: providers}
+}
+
+// Name returns the names of the providers in priority order
+func (p *CompositeProvider) Name() string {
+	names := []string{}
+	for _, pp := range p.providers {
+		names = append(names, pp.Name())
+	}
+	return "composite(" + strings.Join(names, ",") + ")"
+}
+
+// Latest returns the latest rates of the first provider which has them
+func (p *CompositeProvider) Latest() (*Snapshot, error) {
+	var errs []string
+	for _, pp := range p.providers {
+		s, err := pp.Latest()
+		if err == nil {
+			return s, nil
+		}
+		p.log.Warn("Rate provider failed, trying the next one", "provider", pp.Name(), "error", err)
+		errs = append(errs, pp.Name()+": "+err.Error())
+	}
+	return nil, fmt.Errorf("no rate provider succeeded: %s", strings.Join(errs, "; "))
+}
+
+// History returns the history of the first provider which has one
+func (p *CompositeProvider) History() ([]*Snapshot, error) {
+	var errs []string
+	for _, pp := range p.providers {
+		days, err := pp.History()
+		if err == nil {
+			return days, nil
+		}
+		p.log.Warn("Rate provider failed to load history, trying the next one", "provider", pp.Name(), "error", err)
+		errs = append(errs, pp.Name()+": "+err.Error())
+	}
+	return nil, fmt.Errorf("no rate provider succeeded: %s", strings.Join(errs, "; "))
+}
+
+// last returns the most recent of days sorted oldest first
+func last(days []*Snapshot) (*Snapshot, error) {
+	if len(days) == 0 {
+		return nil, fmt.Errorf("no rates in document")
+	}
+	return days[len(days)-1], nil
+}
+
+// sortDays sorts days oldest first, documents usually list the most recent day first
+func sortDays(days []*Snapshot) []*Snapshot {
+	sort.Slice(days, func(i, j int) bool { return days[i].Time.Before(days[j].Time) })
+	return days
+}
+
+// decodeECB decodes an ECB rates document, the daily and the historical
+// documents have the same format with one Cube per day
+func decodeECB(r io.Reader) ([]*Snapshot, error) {
+	md := &Cubes{}
+	err := xml.NewDecoder(r).Decode(&md)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode ECB rates: %w", err)
+	}
+
+	days := []*Snapshot{}
+	for _, d := range md.Days {
+		t, err := time.Parse(DateFormat, d.Time)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date in ECB rates: %w", err)
+		}
+		s := &Snapshot{Time: t, Base: "EUR", Rates: map[string]float64{"EUR": 1}}
+		for _, c := range d.CubeData {
+			r, err := strconv.ParseFloat(c.Rate, 64)
+			if err != nil {
+				return nil, err
+			}
+			s.Rates[c.Currency] = r
+		}
+		days = append(days, s)
+	}
+	return sortDays(days), nil
+}
+
+// decodeCSV decodes the ECB CSV format, currencies without a rate on a day are "N/A" or empty
+func decodeCSV(r io.Reader) ([]*Snapshot, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode CSV rates: %w", err)
+	}
+	if len(rows) == 0 || !strings.EqualFold(rows[0][0], "Date") {
+		return nil, fmt.Errorf("unable to decode CSV rates: expected a Date header")
+	}
+
+	header := rows[0]
+	days := []*Snapshot{}
+	for _, row := range rows[1:] {
+		t, err := time.Parse(DateFormat, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid date in CSV rates: %w", err)
+		}
+		s := &Snapshot{Time: t, Base: "EUR", Rates: map[string]float64{"EUR": 1}}
+		for i := 1; i < len(row) && i < len(header); i++ {
+			if header[i] == "" || row[i] == "" || row[i] == "N/A" {
+				continue
+			}
+			r, err := strconv.ParseFloat(row[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s rate on %s: %w", header[i], row[0], err)
+			}
+			s.Rates[header[i]] = r
+		}
+		days = append(days, s)
+	}
+	return sortDays(days), nil
+}
+
+// jsonDay is a day of rates in a JSON rates file
+type jsonDay struct {
+	Date string `json:"date"`
+	// Base is the currency the rates are quoted against, EUR when omitted
+	Base  string             `json:"base"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// decodeJSON decodes a single jsonDay or a list of them
+func decodeJSON(r io.Reader) ([]*Snapshot, error) {
+	d, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	jds := []jsonDay{}
+	if strings.HasPrefix(strings.TrimSpace(string(d)), "[") {
+		err = json.Unmarshal(d, &jds)
+	} else {
+		jd := jsonDay{}
+		err = json.Unmarshal(d, &jd)
+		jds = append(jds, jd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode JSON rates: %w", err)
+	}
+
+	days := []*Snapshot{}
+	for _, jd := range jds {
+		t, err := time.Parse(DateFormat, jd.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date in JSON rates: %w", err)
+		}
+		base := strings.ToUpper(jd.Base)
+		if base == "" {
+			base = "EUR"
+		}
+		s := &Snapshot{Time: t, Base: base, Rates: map[string]float64{base: 1}}
+		for k, v := range jd.Rates {
+			s.Rates[k] = v
+		}
+		days = append(days, s)
+	}
+	return sortDays(days), nil
+}
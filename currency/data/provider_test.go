@@ -0,0 +1,55 @@
+package data
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProviderFormats(t *testing.T) {
+	for _, f := range []string{"eurofxref-hist-90d.xml", "eurofxref-hist.csv", "rates.json"} {
+		p, err := NewFileProvider("testdata/" + f)
+		assert.NoError(t, err)
+
+		s, err := p.Latest()
+		assert.NoError(t, err, f)
+		assert.Equal(t, day("2023-03-10"), s.Time, f)
+		assert.Equal(t, 1.0581, s.Rates["USD"], f)
+		assert.Equal(t, 1.0, s.Rates["EUR"], f)
+	}
+
+	// N/A marks a currency without a rate on the day
+	p, _ := NewFileProvider("testdata/eurofxref-hist.csv")
+	days, err := p.History()
+	assert.NoError(t, err)
+	assert.Len(t, days, 3)
+	assert.NotContains(t, days[0].Rates, "USD")
+
+	_, err = NewFileProvider("testdata/rates.yaml")
+	assert.Error(t, err)
+}
+
+type failingProvider struct{}
+
+func (failingProvider) Name() string                  { return "failing" }
+func (failingProvider) Latest() (*Snapshot, error)    { return nil, fmt.Errorf("unavailable") }
+func (failingProvider) History() ([]*Snapshot, error) { return nil, fmt.Errorf("unavailable") }
+
+func TestCompositeProviderFallback(t *testing.T) {
+	fp, _ := NewFileProvider("testdata/rates.json")
+	p := NewCompositeProvider(hclog.Default(), failingProvider{}, fp)
+	assert.Equal(t, "composite(failing,file)", p.Name())
+
+	s, err := p.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, 0.8866, s.Rates["GBP"])
+
+	days, err := p.History()
+	assert.NoError(t, err)
+	assert.Len(t, days, 2)
+
+	_, err = NewCompositeProvider(hclog.Default(), failingProvider{}).Latest()
+	assert.Error(t, err)
+}
@@ -1,58 +1,126 @@
 package data
 
 import (
-	"encoding/xml"
 	"fmt"
-	"math/rand"
-	"net/http"
-	"strconv"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
 )
 
+// ECB reference rate documents, all rates are quoted against EUR
+const (
+	ECBDailyURL          = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+	ECBHistory90DaysURL  = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"
+	ECBHistoryURL        = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml"
+	maxIntradaySnapshots = 10000
+)
+
+// ExchangeRates holds the current rates against the pivot currency and their history,
+// it is safe for concurrent use by the gRPC handlers, a Simulator and the provider retry.
+// Rates from providers quoting against another base are converted to the pivot when loaded.
 type ExchangeRates struct {
-	log   hclog.Logger
-	rates map[string]float64
-}
-
-// MonitorRates checks the rates in the ECB API every interval and sends a message to the
-// returned channel when there are changes
-//
-// Note: the ECB API only returns data once a day, this function only simulates the changes
-// in rates for demonstration purposes
-func (e *ExchangeRates) MonitorRates(interval time.Duration) chan struct{} {
-	ret := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(interval)
-		for {
-			<-ticker.C
-			// just add a random difference to the rate and return it
-			// this simulates the fluctuations in currency rates
-			for k, v := range e.rates {
-				// change can be 10% of original value
-				change := (rand.Float64() / 10)
-				// is this a postive or negative change
-				direction := rand.Intn(2)
-				if direction == 0 {
-					// new value with be min 90% of old
-					change = 1 - change
-				} else {
-					// new value will be 110% of old
-					change = 1 + change
-				}
-				// modify the rate
-				e.rates[k] = v * change
-			}
-			// notify updates, this will block unless there is a listener on the other end
-			ret <- struct{}{}
+	log     hclog.Logger
+	history *History
+	pivot   string
+
+	provider RateProvider
+	snapshot *SnapshotFile
+
+	// mu guards rates, status and listeners
+	mu     sync.RWMutex
+	rates  map[string]float64
+	status Status
+	// listeners are signalled after every change of the rates
+	listeners []chan struct{}
+}
+
+// Status describes where the current reference rates came from
+type Status struct {
+	// Source is the name of the provider, or "snapshot" when running from the persisted rates
+	Source string
+	// Date is the day of the reference rates
+	Date time.Time
+	// FetchedAt is when the rates were fetched from the provider
+	FetchedAt time.Time
+}
+
+// Age returns how long ago the rates were fetched from the provider
+func (s Status) Age() time.Duration {
+	return time.Since(s.FetchedAt)
+}
+
+// Offline returns true when the rates come from the snapshot as the provider is unreachable
+func (s Status) Offline() bool {
+	return s.Source == SourceSnapshot
+}
+
+// SourceSnapshot is the Status Source when the rates were loaded from the SnapshotFile
+const SourceSnapshot = "snapshot"
+
+// Status returns where the current reference rates came from
+func (e *ExchangeRates) Status() Status {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.status
+}
+
+// MonitorRates returns a new channel which receives a message when the rates change, either
+// by a Simulator or when the provider is reachable again. Changes happening while the
+// previous message has not been received yet are coalesced into a single message.
+func (e *ExchangeRates) MonitorRates() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	e.listeners = append(e.listeners, ch)
+	return ch
+}
+
+// Update applies fn to the current rates and notifies MonitorRates. fn must not retain the map.
+// The changes are not recorded in the history, which only holds rates from the provider.
+func (e *ExchangeRates) Update(fn func(rates map[string]float64)) {
+	e.mu.Lock()
+	fn(e.rates)
+	e.mu.Unlock()
+
+	e.notify()
+}
+
+// replace sets rates freshly loaded from the provider
+func (e *ExchangeRates) replace(s *Snapshot) {
+	e.mu.Lock()
+	e.rates = copyRates(s.Rates)
+	e.status = Status{Source: e.provider.Name(), Date: s.Time, FetchedAt: time.Now()}
+	e.history.Record(time.Now(), e.pivot, e.rates)
+	e.mu.Unlock()
+
+	e.notify()
+}
+
+// notify signals a change without blocking, a pending signal already covers it
+func (e *ExchangeRates) notify() {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, ch := range e.listeners {
+		select {
+		case ch <- struct{}{}:
+		default:
 		}
-	}()
-	return ret
+	}
 }
 
-// GetRate fetches currency rate for given base & destination currencies
+// Pivot returns the currency all rates are stored against
+func (e *ExchangeRates) Pivot() string {
+	return e.pivot
+}
+
+// GetRate fetches currency rate for given base & destination currencies, the rate
+// is triangulated through the pivot: rate(base->dest) = rate(pivot->dest) / rate(pivot->base)
 func (e *ExchangeRates) GetRate(base, dest string) (float64, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	br, ok := e.rates[base]
 	if !ok {
 		return 0, fmt.Errorf("rate not found for currency %s", base)
@@ -64,44 +132,161 @@ func (e *ExchangeRates) GetRate(base, dest string) (float64, error) {
 	return dr / br, nil
 }
 
-// NewRates instantiates a new ExchangeRates
-func NewRates(l hclog.Logger) (*ExchangeRates, error) {
-	er := &ExchangeRates{log: l, rates: map[string]float64{}}
-	err := er.fetchRatesFromECB()
-	return er, err
+// Currencies returns the codes of all currencies with a known rate, sorted
+func (e *ExchangeRates) Currencies() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	cs := []string{}
+	for c := range e.rates {
+		cs = append(cs, c)
+	}
+	sort.Strings(cs)
+	return cs
 }
 
-// GetRates fetches currency rates against EUR for various currencies from eu-central-bank
-func (e *ExchangeRates) fetchRatesFromECB() error {
-	resp, err := http.DefaultClient.Get("https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml")
+// GetHistoricalRate returns the rate for given base & destination currencies at the end of the
+// given day, or the closest earlier day with rates such as the Friday before a weekend.
+// The time of the snapshot used is returned along with the rate.
+func (e *ExchangeRates) GetHistoricalRate(base, dest string, date time.Time) (float64, time.Time, error) {
+	s, err := e.history.At(endOfDay(date))
 	if err != nil {
-		return err
+		return 0, time.Time{}, err
 	}
+	r, err := s.rate(base, dest)
+	return r, s.Time, err
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected status_code 200, got %d", resp.StatusCode)
+// GetRateSeries returns the rates for given base & destination currencies for every
+// snapshot from the start of the first day to the end of the last day
+func (e *ExchangeRates) GetRateSeries(base, dest string, from, to time.Time) ([]RatePoint, error) {
+	ps := []RatePoint{}
+	for _, s := range e.history.Between(from.UTC().Truncate(24*time.Hour), endOfDay(to)) {
+		r, err := s.rate(base, dest)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, RatePoint{Time: s.Time, Rate: r})
+	}
+	return ps, nil
+}
+
+// endOfDay returns the last instant of the UTC day of t
+func endOfDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour).Add(24*time.Hour - time.Nanosecond)
+}
+
+// NewRates instantiates a new ExchangeRates with the latest rates and the history of the provider,
+// stored against pivot. Rates loaded from the provider are saved to sf, when the provider is unreachable the rates saved
+// in sf are used instead and the provider is retried every retry interval, backing off up to ten
+// times that, until it answers. sf may be nil to disable the snapshot.
+func NewRates(l hclog.Logger, p RateProvider, pivot string, sf *SnapshotFile, retry time.Duration) (*ExchangeRates, error) {
+	er := &ExchangeRates{
+		log:      l,
+		pivot:    pivot,
+		rates:    map[string]float64{},
+		history:  NewHistory(maxIntradaySnapshots),
+		provider: p,
+		snapshot: sf,
+	}
+
+	latest, err := er.load()
+	if err == nil {
+		er.rates = copyRates(latest.Rates)
+		er.status = Status{Source: p.Name(), Date: latest.Time, FetchedAt: time.Now()}
+		return er, nil
+	}
+	if sf == nil {
+		return er, err
 	}
-	defer resp.Body.Close()
 
-	// resp in XML : set rates map in ExchangeRates instance
-	md := &Cubes{}
-	xml.NewDecoder(resp.Body).Decode(&md)
-	for _, c := range md.CubeData {
-		r, err := strconv.ParseFloat(c.Rate, 64)
+	l.Error("Unable to load rates from provider, using the last saved rates", "provider", p.Name(), "error", err)
+	s, fetchedAt, serr := sf.Load()
+	if serr == nil {
+		s, serr = s.Rebase(pivot)
+	}
+	if serr != nil {
+		return er, fmt.Errorf("%w, and no saved rates: %s", err, serr)
+	}
+	er.history.AddDaily(s)
+	er.rates = copyRates(s.Rates)
+	er.status = Status{Source: SourceSnapshot, Date: s.Time, FetchedAt: fetchedAt}
+	l.Warn("Loaded saved rates", "date", s.Time.Format(DateFormat), "age", time.Since(fetchedAt).Round(time.Second))
+
+	go er.retry(retry)
+	return er, nil
+}
+
+// load fetches the latest rates and the history from the provider, converted to the pivot,
+// and saves the latest rates
+func (e *ExchangeRates) load() (*Snapshot, error) {
+	latest, err := e.provider.Latest()
+	if err != nil {
+		return nil, err
+	}
+	latest, err = latest.Rebase(e.pivot)
+	if err != nil {
+		return nil, err
+	}
+	e.history.AddDaily(latest)
+	e.log.Info("Loaded latest rates", "provider", e.provider.Name(), "date", latest.Time.Format(DateFormat), "pivot", e.pivot)
+
+	if e.snapshot != nil {
+		err = e.snapshot.Save(latest, e.provider.Name(), time.Now())
+		if err != nil {
+			e.log.Error("Unable to save rates snapshot", "error", err)
+		}
+	}
+
+	days, err := e.provider.History()
+	if err != nil {
+		// history is optional, the latest rates are enough to serve requests
+		e.log.Error("Unable to load historical rates", "provider", e.provider.Name(), "error", err)
+		return latest, nil
+	}
+	n := 0
+	for _, d := range days {
+		d, err = d.Rebase(e.pivot)
 		if err != nil {
-			return err
+			// skip the day, the other days can still be served
+			e.log.Error("Unable to convert historical rates to the pivot", "provider", e.provider.Name(), "error", err)
+			continue
 		}
-		e.rates[c.Currency] = r
+		e.history.AddDaily(d)
+		n++
 	}
-	e.rates["EUR"] = 1
-	// for key, element := range e.rates {
-	// 	fmt.Println("Key:", key, "=>", "Element:", element)
-	// }
-	return nil
+	e.log.Info("Loaded historical rates", "provider", e.provider.Name(), "days", n)
+	return latest, nil
 }
 
+// retry loads the rates from the provider until it succeeds and replaces the saved rates with them
+func (e *ExchangeRates) retry(interval time.Duration) {
+	wait := interval
+	for {
+		time.Sleep(wait)
+		latest, err := e.load()
+		if err == nil {
+			e.log.Info("Rate provider is reachable again", "provider", e.provider.Name())
+			e.replace(latest)
+			return
+		}
+		wait *= 2
+		if wait > 10*interval {
+			wait = 10 * interval
+		}
+		e.log.Error("Unable to load rates from provider", "provider", e.provider.Name(), "retry_in", wait, "error", err)
+	}
+}
+
+// Cubes is the envelope of an ECB rates document
 type Cubes struct {
-	CubeData []Cube `xml:"Cube>Cube>Cube"`
+	Days []CubeDay `xml:"Cube>Cube"`
+}
+
+// CubeDay holds the rates for a single day
+type CubeDay struct {
+	Time     string `xml:"time,attr"`
+	CubeData []Cube `xml:"Cube"`
 }
 
 type Cube struct {
@@ -1,19 +1,139 @@
 package data
 
 import (
-	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
 )
 
+// newECBServer serves the recorded ECB documents in testdata
+func newECBServer(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	t.Cleanup(s.Close)
+	return s
+}
+
 func TestNewRates(t *testing.T) {
-	tr, err := NewRates(hclog.Default())
+	s := newECBServer(t)
+	p := NewECBProvider(s.Client(), s.URL+"/eurofxref-daily.xml", s.URL+"/eurofxref-hist-90d.xml")
+
+	tr, err := NewRates(hclog.Default(), p, "EUR", nil, time.Second)
+	assert.NoError(t, err)
+
+	r, err := tr.GetRate("EUR", "GBP")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.8866, r)
+
+	r, _, err = tr.GetHistoricalRate("EUR", "USD", day("2023-03-08"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0549, r)
+}
+
+func TestNewRatesProviderDown(t *testing.T) {
+	s := newECBServer(t)
+	p := NewECBProvider(s.Client(), s.URL+"/missing.xml", "")
+
+	_, err := NewRates(hclog.Default(), p, "EUR", nil, time.Second)
+	assert.Error(t, err)
+}
+
+func TestNewRatesWithoutHistory(t *testing.T) {
+	s := newECBServer(t)
+	p := NewECBProvider(s.Client(), s.URL+"/eurofxref-daily.xml", "")
+
+	tr, err := NewRates(hclog.Default(), p, "EUR", nil, time.Second)
+	assert.NoError(t, err)
+	ps, err := tr.GetRateSeries("EUR", "USD", day("2023-03-01"), time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, ps, 1)
+}
+
+func TestNewRatesFromSnapshot(t *testing.T) {
+	s := newECBServer(t)
+	sf := NewSnapshotFile(filepath.Join(t.TempDir(), "rates.json"))
+
+	// a successful start saves the rates
+	_, err := NewRates(hclog.Default(), NewECBProvider(s.Client(), s.URL+"/eurofxref-daily.xml", ""), "EUR", sf, time.Second)
+	assert.NoError(t, err)
 
-	if err != nil {
-		t.Fatal(err)
+	// the provider is down, the saved rates are used and the provider retried
+	var up int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 {
+			http.Error(rw, "down", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeFile(rw, r, "testdata/eurofxref-daily.xml")
+	}))
+	defer flaky.Close()
+
+	tr, err := NewRates(hclog.Default(), NewECBProvider(flaky.Client(), flaky.URL, ""), "EUR", sf, 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.True(t, tr.Status().Offline())
+	assert.Equal(t, day("2023-03-10"), tr.Status().Date)
+	r, err := tr.GetRate("EUR", "GBP")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.8866, r)
+
+	// once the provider is back its rates replace the saved ones
+	updates := tr.MonitorRates()
+	atomic.StoreInt32(&up, 1)
+	select {
+	case <-updates:
+	case <-time.After(5 * time.Second):
+		t.Fatal("rates were not refreshed from the provider")
 	}
+	assert.Equal(t, "ecb", tr.Status().Source)
+
+	// without provider and snapshot there are no rates at all
+	_, err = NewRates(hclog.Default(), NewECBProvider(flaky.Client(), s.URL+"/missing.xml", ""), "EUR", NewSnapshotFile(filepath.Join(t.TempDir(), "none.json")), time.Second)
+	assert.Error(t, err)
+}
+
+func TestNewRatesTriangulatesThroughPivot(t *testing.T) {
+	fp, err := NewFileProvider("testdata/rates-usd.json")
+	assert.NoError(t, err)
+
+	tr, err := NewRates(hclog.Default(), fp, "EUR", nil, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "EUR", tr.Pivot())
+
+	r, err := tr.GetRate("EUR", "USD")
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.0581, r, 0.0001)
+
+	r, err = tr.GetRate("GBP", "JPY")
+	assert.NoError(t, err)
+	assert.InDelta(t, 136.25/0.83791, r, 0.0001)
+
+	_, err = NewRates(hclog.Default(), fp, "INR", nil, time.Second)
+	assert.Error(t, err)
+}
+
+func TestSimulatedRatesAreNotHistory(t *testing.T) {
+	s := newECBServer(t)
+	p := NewECBProvider(s.Client(), s.URL+"/eurofxref-daily.xml", "")
+
+	tr, err := NewRates(hclog.Default(), p, "EUR", nil, time.Second)
+	assert.NoError(t, err)
+	before, _, err := tr.GetHistoricalRate("EUR", "USD", time.Now())
+	assert.NoError(t, err)
+
+	tr.Update(func(rates map[string]float64) { rates["USD"] *= 2 })
+
+	r, _, err := tr.GetHistoricalRate("EUR", "USD", time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, before, r)
+	ps, err := tr.GetRateSeries("EUR", "USD", day("2023-03-01"), time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, ps, 1)
 
-	fmt.Printf("Rates %#v", tr.rates)
-	// run via terminal in currency root: go test -v ./data
+	_, intraday := tr.history.Len()
+	assert.Equal(t, 0, intraday)
 }
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Simulator fluctuates the rates of an ExchangeRates at a fixed interval.
+//
+// Note: the ECB API only returns data once a day, the simulated changes in rates
+// are for demonstration purposes so clients of the rate streams see updates
+type Simulator struct {
+	interval   time.Duration
+	volatility float64
+	frozen     bool
+
+	// mu guards rng, rand.Rand is not safe for concurrent use
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewSimulator creates a Simulator changing every rate by up to volatility, a fraction
+// such as 0.1 for 10%, every interval. The same seed always produces the same changes.
+func NewSimulator(seed int64, volatility float64, interval time.Duration) *Simulator {
+	return &Simulator{
+		interval:   interval,
+		volatility: volatility,
+		rng:        rand.New(rand.NewSource(seed)),
+		stop:       make(chan struct{}),
+	}
+}
+
+// NewFrozenSimulator creates a Simulator which never changes the rates,
+// for integration tests which need stable prices
+func NewFrozenSimulator() *Simulator {
+	return &Simulator{frozen: true, stop: make(chan struct{})}
+}
+
+// Frozen returns true when the simulator does not change the rates
+func (s *Simulator) Frozen() bool {
+	return s.frozen
+}
+
+// Start changes the rates of er every interval until Stop is called,
+// a frozen Simulator does nothing
+func (s *Simulator) Start(er *ExchangeRates) {
+	if s.frozen {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				er.Update(s.Step)
+			}
+		}
+	}()
+}
+
+// Stop stops changing the rates
+func (s *Simulator) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// Step applies one random change to every rate
+func (s *Simulator) Step(rates map[string]float64) {
+	if s.frozen {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// iterate in a fixed order, map order is random and would break seeded runs
+	cs := make([]string, 0, len(rates))
+	for c := range rates {
+		cs = append(cs, c)
+	}
+	sort.Strings(cs)
+
+	for _, c := range cs {
+		// change can be volatility of original value
+		change := s.rng.Float64() * s.volatility
+		// is this a postive or negative change
+		if s.rng.Intn(2) == 0 {
+			change = 1 - change
+		} else {
+			change = 1 + change
+		}
+		rates[c] = rates[c] * change
+	}
+}
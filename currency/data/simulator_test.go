@@ -0,0 +1,64 @@
+package data
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func testRates() map[string]float64 {
+	return map[string]float64{"EUR": 1, "USD": 1.0581, "GBP": 0.8866, "INR": 86.971}
+}
+
+func TestSimulatorIsDeterministic(t *testing.T) {
+	a, b := testRates(), testRates()
+	s1, s2 := NewSimulator(42, 0.1, time.Second), NewSimulator(42, 0.1, time.Second)
+	for i := 0; i < 10; i++ {
+		s1.Step(a)
+		s2.Step(b)
+	}
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, testRates(), a)
+}
+
+func TestFrozenSimulator(t *testing.T) {
+	rates := testRates()
+	s := NewFrozenSimulator()
+	s.Step(rates)
+	assert.True(t, s.Frozen())
+	assert.Equal(t, testRates(), rates)
+}
+
+// TestConcurrentUpdates is meant for go test -race
+func TestConcurrentUpdates(t *testing.T) {
+	fp, _ := NewFileProvider("testdata/rates.json")
+	er, err := NewRates(hclog.Default(), fp, "EUR", nil, time.Second)
+	assert.NoError(t, err)
+
+	sim := NewSimulator(1, 0.1, time.Millisecond)
+	sim.Start(er)
+	defer sim.Stop()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := er.GetRate("GBP", "USD")
+				assert.NoError(t, err)
+				er.Currencies()
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-er.MonitorRates():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rate changes were signalled")
+	}
+}
@@ -0,0 +1,88 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotFile persists the last rates loaded from a provider so the service
+// can start without the provider, e.g. when there is no internet connection
+type SnapshotFile struct {
+	path string
+}
+
+// NewSnapshotFile creates a SnapshotFile stored at path
+func NewSnapshotFile(path string) *SnapshotFile {
+	return &SnapshotFile{path: path}
+}
+
+// snapshotDoc is the JSON document written to disk
+type snapshotDoc struct {
+	Date      string             `json:"date"`
+	Provider  string             `json:"provider"`
+	Base      string             `json:"base"`
+	FetchedAt time.Time          `json:"fetched_at"`
+	Rates     map[string]float64 `json:"rates"`
+}
+
+// Save writes the rates fetched from provider at fetchedAt, the file is replaced
+// atomically so a crash never leaves a partial snapshot behind
+func (sf *SnapshotFile) Save(s *Snapshot, provider string, fetchedAt time.Time) error {
+	d, err := json.MarshalIndent(snapshotDoc{
+		Date:      s.Time.Format(DateFormat),
+		Provider:  provider,
+		Base:      s.Base,
+		FetchedAt: fetchedAt.UTC(),
+		Rates:     s.Rates,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(sf.path), filepath.Base(sf.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(d)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sf.path)
+}
+
+// Load reads the snapshot and returns it with the time it was fetched from the provider
+func (sf *SnapshotFile) Load() (*Snapshot, time.Time, error) {
+	d, err := os.ReadFile(sf.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	doc := snapshotDoc{}
+	err = json.Unmarshal(d, &doc)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("unable to decode rates snapshot %s: %w", sf.path, err)
+	}
+	t, err := time.Parse(DateFormat, doc.Date)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid date in rates snapshot %s: %w", sf.path, err)
+	}
+	if len(doc.Rates) == 0 {
+		return nil, time.Time{}, fmt.Errorf("rates snapshot %s has no rates", sf.path)
+	}
+	if doc.Base == "" {
+		// snapshots saved before rates carried their base are quoted against EUR
+		doc.Base = "EUR"
+	}
+	return &Snapshot{Time: t, Base: doc.Base, Rates: doc.Rates}, doc.FetchedAt, nil
+}
@@ -2,7 +2,19 @@ module github.com/satoshi-u/go-microservices/currency
 
 go 1.18
 
-require github.com/hashicorp/go-hclog v1.3.0
+require (
+	github.com/gorilla/mux v1.8.0
+	github.com/hashicorp/go-hclog v1.3.0
+	github.com/nicholasjackson/env v0.6.0
+	github.com/satoshi-u/go-microservices/webhook v0.0.0-00010101000000-000000000000
+	github.com/stretchr/testify v1.8.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
 
 require (
 	github.com/cloudflare/cfssl v1.6.2
@@ -13,7 +25,9 @@ require (
 	golang.org/x/net v0.5.0 // indirect
 	golang.org/x/sys v0.4.0 // indirect
 	golang.org/x/text v0.6.0 // indirect
-	google.golang.org/genproto v0.0.0-20230110181048-76db0878b65f // indirect
+	google.golang.org/genproto v0.0.0-20230110181048-76db0878b65f
 	google.golang.org/grpc v1.53.0
 	google.golang.org/protobuf v1.28.1
 )
+
+replace github.com/satoshi-u/go-microservices/webhook => ../webhook
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/data"
+)
+
+// Health reports whether the rates are fresh or served from the saved snapshot
+type Health struct {
+	log   hclog.Logger
+	rates *data.ExchangeRates
+}
+
+// NewHealth creates a new Health handler
+func NewHealth(l hclog.Logger, er *data.ExchangeRates) *Health {
+	return &Health{log: l, rates: er}
+}
+
+// HealthResponse is the body of GET /health
+type HealthResponse struct {
+	// Status is "ok", or "degraded" when the provider is unreachable and saved rates are used
+	Status     string    `json:"status"`
+	Source     string    `json:"source"`
+	RatesDate  string    `json:"rates_date"`
+	FetchedAt  time.Time `json:"fetched_at"`
+	AgeSeconds int64     `json:"age_seconds"`
+}
+
+// ServeHTTP handles GET /health, it always answers 200 as the service can serve rates either way
+func (h *Health) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	s := h.rates.Status()
+	resp := HealthResponse{
+		Status:     "ok",
+		Source:     s.Source,
+		RatesDate:  s.Date.Format(data.DateFormat),
+		FetchedAt:  s.FetchedAt,
+		AgeSeconds: int64(s.Age().Seconds()),
+	}
+	if s.Offline() {
+		resp.Status = "degraded"
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(rw).Encode(resp)
+	if err != nil {
+		h.log.Error("Unable to serialize health", "error", err)
+	}
+}
+
+// RatesStatus is a middleware adding the X-Rates-Source, X-Rates-Date and X-Rates-Age
+// headers to every response, like the gRPC server does with response metadata
+func RatesStatus(er *data.ExchangeRates) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			s := er.Status()
+			rw.Header().Set("X-Rates-Source", s.Source)
+			rw.Header().Set("X-Rates-Date", s.Date.Format(data.DateFormat))
+			rw.Header().Set("X-Rates-Age", strconv.Itoa(int(s.Age().Seconds())))
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/satoshi-u/go-microservices/currency/server"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Rates is a HTTP/JSON facade for the Currency gRPC server, requests go
+// through the same server.Currency methods so validation is shared
+type Rates struct {
+	log hclog.Logger
+	cs  *server.Currency
+}
+
+// NewRates creates a new Rates handler
+func NewRates(l hclog.Logger, cs *server.Currency) *Rates {
+	return &Rates{log: l, cs: cs}
+}
+
+// marshaler writes JSON with the same field names as the proto definitions,
+// zero values are always present
+var marshaler = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// GetRate handles GET /v1/rates?base=GBP&dest=INR and returns a RateResponse as JSON
+func (h *Rates) GetRate(rw http.ResponseWriter, r *http.Request) {
+	rr := rateRequest(r.URL.Query().Get("base"), r.URL.Query().Get("dest"))
+
+	resp, err := h.cs.GetRate(r.Context(), rr)
+	if err != nil {
+		h.writeError(rw, err)
+		return
+	}
+	h.writeJSON(rw, http.StatusOK, resp)
+}
+
+// Convert handles GET /v1/convert?base=EUR&dest=INR&amount=12.34&rounding=ROUND_HALF_UP
+// and returns a ConvertResponse as JSON
+func (h *Rates) Convert(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rr := rateRequest(q.Get("base"), q.Get("dest"))
+	cr := &pb.ConvertRequest{Base: rr.Base, Destination: rr.Destination, Amount: q.Get("amount")}
+	if rm := q.Get("rounding"); rm != "" {
+		v, ok := pb.RoundingMode_value[strings.ToUpper(rm)]
+		if !ok {
+			h.writeError(rw, status.Errorf(codes.InvalidArgument, "unknown rounding mode %q", rm))
+			return
+		}
+		cr.Rounding = pb.RoundingMode(v)
+	}
+
+	resp, err := h.cs.Convert(r.Context(), cr)
+	if err != nil {
+		h.writeError(rw, err)
+		return
+	}
+	h.writeJSON(rw, http.StatusOK, resp)
+}
+
+// GetHistoricalRate handles GET /v1/rates/history?base=GBP&dest=INR&date=2023-03-10
+// and returns a HistoricalRateResponse as JSON
+func (h *Rates) GetHistoricalRate(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rr := rateRequest(q.Get("base"), q.Get("dest"))
+
+	resp, err := h.cs.GetHistoricalRate(r.Context(), &pb.HistoricalRateRequest{Base: rr.Base, Destination: rr.Destination, Date: q.Get("date")})
+	if err != nil {
+		h.writeError(rw, err)
+		return
+	}
+	h.writeJSON(rw, http.StatusOK, resp)
+}
+
+// GetRateSeries handles GET /v1/rates/series?base=GBP&dest=INR&start=2023-03-01&end=2023-03-10
+// and returns a RateSeriesResponse as JSON
+func (h *Rates) GetRateSeries(rw http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rr := rateRequest(q.Get("base"), q.Get("dest"))
+
+	resp, err := h.cs.GetRateSeries(r.Context(), &pb.RateSeriesRequest{Base: rr.Base, Destination: rr.Destination, Start: q.Get("start"), End: q.Get("end")})
+	if err != nil {
+		h.writeError(rw, err)
+		return
+	}
+	h.writeJSON(rw, http.StatusOK, resp)
+}
+
+// ListSubscriptions handles GET /v1/subscriptions on the admin server and returns a
+// ListSubscriptionsResponse as JSON, it lists peer addresses so it is not for the public facade
+func (h *Rates) ListSubscriptions(rw http.ResponseWriter, r *http.Request) {
+	resp, err := h.cs.ListSubscriptions(r.Context(), &pb.ListSubscriptionsRequest{})
+	if err != nil {
+		h.writeError(rw, err)
+		return
+	}
+	h.writeJSON(rw, http.StatusOK, resp)
+}
+
+// rateRequest builds a RateRequest from currency codes given in a URL, codes are
+// case insensitive and validated by the Currency server like in the gRPC API
+func rateRequest(base, dest string) *pb.RateRequest {
+	return &pb.RateRequest{Base: strings.ToUpper(base), Destination: strings.ToUpper(dest)}
+}
+
+// ListCurrencies handles GET /v1/currencies and returns a ListCurrenciesResponse as JSON
+func (h *Rates) ListCurrencies(rw http.ResponseWriter, r *http.Request) {
+	resp, err := h.cs.ListCurrencies(r.Context(), &pb.ListCurrenciesRequest{})
+	if err != nil {
+		h.writeError(rw, err)
+		return
+	}
+	h.writeJSON(rw, http.StatusOK, resp)
+}
+
+// writeJSON writes a proto message as JSON
+func (h *Rates) writeJSON(rw http.ResponseWriter, code int, m proto.Message) {
+	d, err := marshaler.Marshal(m)
+	if err != nil {
+		h.log.Error("Unable to serialize response", "error", err)
+		http.Error(rw, "unable to serialize response", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	rw.Write(d)
+}
+
+// writeError writes a gRPC status error as a JSON google.rpc.Status with the
+// HTTP status code matching its gRPC code
+func (h *Rates) writeError(rw http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	h.writeJSON(rw, httpStatus(s.Code()), s.Proto())
+}
+
+// httpStatus maps gRPC codes to HTTP status codes, following the grpc-gateway conventions
+func httpStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument, codes.OutOfRange, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Canceled:
+		return 499
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// StreamRates handles GET /v1/rates/stream?base=GBP&dest=INR&dest=USD and streams
+// rate updates as Server-Sent Events, it is a SubscribeRates call made over HTTP.
+// The optional min_change=0.01 and min_interval=30s apply to every pair.
+func (h *Rates) StreamRates(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		h.writeError(rw, status.Error(codes.Internal, "streaming is not supported"))
+		return
+	}
+
+	base := r.URL.Query().Get("base")
+	dests := r.URL.Query()["dest"]
+	if len(dests) == 0 {
+		h.writeError(rw, status.Error(codes.InvalidArgument, "at least one dest currency is required"))
+		return
+	}
+
+	var minChange float64
+	if mc := r.URL.Query().Get("min_change"); mc != "" {
+		v, err := strconv.ParseFloat(mc, 64)
+		if err != nil {
+			h.writeError(rw, status.Errorf(codes.InvalidArgument, "min_change must be a number, got %q", mc))
+			return
+		}
+		minChange = v
+	}
+	var minInterval *durationpb.Duration
+	if mi := r.URL.Query().Get("min_interval"); mi != "" {
+		v, err := time.ParseDuration(mi)
+		if err != nil {
+			h.writeError(rw, status.Errorf(codes.InvalidArgument, "min_interval must be a duration such as 30s, got %q", mi))
+			return
+		}
+		minInterval = durationpb.New(v)
+	}
+
+	// validate every pair and get its current rate before the stream is started,
+	// so invalid requests get a normal error response
+	initial := []*pb.RateResponse{}
+	rrs := []*pb.RateRequest{}
+	for _, d := range dests {
+		rr := rateRequest(base, d)
+		rr.MinChange = minChange
+		rr.MinInterval = minInterval
+		resp, err := h.cs.GetRate(r.Context(), rr)
+		if err != nil {
+			h.writeError(rw, err)
+			return
+		}
+		initial = append(initial, resp)
+		rrs = append(rrs, rr)
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+
+	for _, resp := range initial {
+		if h.writeEvent(rw, flusher, "rate", resp) != nil {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stream := newLocalStream(ctx, rrs)
+
+	// SubscribeRates returns when the client goes away and the stream context is done
+	errs := make(chan error, 1)
+	go func() {
+		errs <- h.cs.SubscribeRates(stream)
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			if err != nil && ctx.Err() == nil {
+				h.log.Error("Rate subscription ended", "error", err)
+			}
+			return
+		case m := <-stream.out:
+			var err error
+			if e := m.GetError(); e != nil {
+				err = h.writeEvent(rw, flusher, "error", e)
+			} else {
+				err = h.writeEvent(rw, flusher, "rate", m.GetRateResponse())
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with a proto message as JSON data
+func (h *Rates) writeEvent(rw http.ResponseWriter, f http.Flusher, event string, m proto.Message) error {
+	d, err := marshaler.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", event, d)
+	if err != nil {
+		return err
+	}
+	f.Flush()
+	return nil
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/data"
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/satoshi-u/go-microservices/currency/server"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func setupRates(t *testing.T) (*mux.Router, *server.Currency, *data.ExchangeRates) {
+	fp, err := data.NewFileProvider("../data/testdata/rates.json")
+	assert.NoError(t, err)
+	er, err := data.NewRates(hclog.NewNullLogger(), fp, "EUR", nil, time.Second)
+	assert.NoError(t, err)
+	cs := server.NewCurrency(hclog.NewNullLogger(), er)
+	rh := NewRates(hclog.NewNullLogger(), cs)
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/v1/rates", rh.GetRate)
+	sm.HandleFunc("/v1/rates/stream", rh.StreamRates)
+	sm.HandleFunc("/v1/convert", rh.Convert)
+	sm.HandleFunc("/v1/rates/history", rh.GetHistoricalRate)
+	sm.HandleFunc("/v1/currencies", rh.ListCurrencies)
+	return sm, cs, er
+}
+
+func get(sm http.Handler, url string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	return rr
+}
+
+func TestRatesJSON(t *testing.T) {
+	sm, _, _ := setupRates(t)
+
+	rr := get(sm, "/v1/rates?base=eur&dest=usd")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	resp := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "USD", resp["Destination"])
+	assert.Equal(t, 1.0581, resp["Rate"])
+
+	rr = get(sm, "/v1/convert?base=EUR&dest=INR&amount=10&rounding=round_half_up")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"Amount":"869.71"`)
+}
+
+func TestRatesErrors(t *testing.T) {
+	sm, _, _ := setupRates(t)
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/v1/rates?base=EUR&dest=XXX", http.StatusBadRequest},
+		{"/v1/rates?base=EUR", http.StatusBadRequest},
+		{"/v1/convert?base=EUR&dest=USD&amount=ten", http.StatusBadRequest},
+		{"/v1/convert?base=EUR&dest=USD&amount=1&rounding=sideways", http.StatusBadRequest},
+		{"/v1/rates/history?base=EUR&dest=USD&date=yesterday", http.StatusBadRequest},
+		{"/v1/rates/stream?base=EUR", http.StatusBadRequest},
+		{"/v1/rates/stream?base=EUR&dest=USD&min_change=lots", http.StatusBadRequest},
+		{"/v1/rates/stream?base=EUR&dest=USD&min_interval=often", http.StatusBadRequest},
+		{"/v1/rates/stream?base=EUR&dest=XXX", http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		rr := get(sm, tc.url)
+		assert.Equal(t, tc.code, rr.Code, tc.url)
+		// errors are google.rpc.Status messages
+		s := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &s), tc.url)
+		assert.Equal(t, float64(codes.InvalidArgument), s["code"], tc.url)
+		assert.NotEmpty(t, s["message"], tc.url)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	for c, want := range map[codes.Code]int{
+		codes.OK:                http.StatusOK,
+		codes.InvalidArgument:   http.StatusBadRequest,
+		codes.NotFound:          http.StatusNotFound,
+		codes.AlreadyExists:     http.StatusConflict,
+		codes.PermissionDenied:  http.StatusForbidden,
+		codes.Unauthenticated:   http.StatusUnauthorized,
+		codes.ResourceExhausted: http.StatusTooManyRequests,
+		codes.Canceled:          499,
+		codes.Unimplemented:     http.StatusNotImplemented,
+		codes.Unavailable:       http.StatusServiceUnavailable,
+		codes.DeadlineExceeded:  http.StatusGatewayTimeout,
+		codes.Internal:          http.StatusInternalServerError,
+		codes.Unknown:           http.StatusInternalServerError,
+	} {
+		assert.Equal(t, want, httpStatus(c), c.String())
+	}
+}
+
+// readEvent reads the next Server-Sent Event as its event name and data
+func readEvent(r *bufio.Reader) (string, string, error) {
+	var event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", "", err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data, nil
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestStreamRates(t *testing.T) {
+	sm, cs, er := setupRates(t)
+	ts := httptest.NewServer(sm)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/rates/stream?base=EUR&dest=USD&dest=gbp", nil)
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// the current rates are sent first
+	br := bufio.NewReader(resp.Body)
+	for _, dest := range []string{"USD", "GBP"} {
+		event, d, err := readEvent(br)
+		assert.NoError(t, err)
+		assert.Equal(t, "rate", event)
+		assert.Contains(t, d, `"Destination":"`+dest+`"`)
+	}
+
+	// then the updates, once the subscriptions are made
+	assert.Eventually(t, func() bool {
+		ls, _ := cs.ListSubscriptions(context.Background(), &pb.ListSubscriptionsRequest{})
+		subs := ls.GetSubscriptions()
+		return len(subs) == 1 && len(subs[0].GetPairs()) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	er.Update(func(rates map[string]float64) { rates["USD"] = 1.2 })
+
+	done := make(chan string)
+	go func() {
+		_, d, _ := readEvent(br)
+		done <- d
+	}()
+	select {
+	case d := <-done:
+		assert.Contains(t, d, `"Destination":"USD"`)
+		assert.Contains(t, d, `"Rate":1.2`)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no rate update")
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// localStream is an in process pb.Currency_SubscribeRatesServer, it lets the
+// HTTP handlers reuse server.Currency.SubscribeRates without a gRPC connection
+type localStream struct {
+	ctx context.Context
+	in  chan *pb.RateRequest
+	out chan *pb.StreamingRateResponse
+}
+
+// newLocalStream creates a stream which receives the given requests and then
+// blocks until ctx is done, as a client which keeps its subscriptions open
+func newLocalStream(ctx context.Context, rrs []*pb.RateRequest) *localStream {
+	in := make(chan *pb.RateRequest, len(rrs))
+	for _, rr := range rrs {
+		in <- rr
+	}
+	return &localStream{ctx: ctx, in: in, out: make(chan *pb.StreamingRateResponse)}
+}
+
+// Send hands the message to the HTTP handler, failing once the client has gone
+func (s *localStream) Send(m *pb.StreamingRateResponse) error {
+	select {
+	case s.out <- m:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// Recv returns the initial requests, then blocks until the context is done
+func (s *localStream) Recv() (*pb.RateRequest, error) {
+	select {
+	case rr := <-s.in:
+		return rr, nil
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	}
+}
+
+func (s *localStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *localStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (s *localStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (s *localStream) SetTrailer(metadata.MD) {}
+
+func (s *localStream) SendMsg(m interface{}) error {
+	return s.Send(m.(*pb.StreamingRateResponse))
+}
+
+func (s *localStream) RecvMsg(m interface{}) error {
+	rr, err := s.Recv()
+	if err != nil {
+		return err
+	}
+	proto.Merge(m.(*pb.RateRequest), rr)
+	return nil
+}
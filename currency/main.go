@@ -1,30 +1,101 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/cloudflare/cfssl/log"
+	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/env"
+	"github.com/satoshi-u/go-microservices/currency/alerts"
 	"github.com/satoshi-u/go-microservices/currency/data"
+	"github.com/satoshi-u/go-microservices/currency/handlers"
 	"github.com/satoshi-u/go-microservices/currency/pb"
 	"github.com/satoshi-u/go-microservices/currency/server"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
+var bindAddress = env.String("BIND_ADDRESS", false, ":9092", "Bind address for the gRPC server")
+var providers = env.String("RATES_PROVIDERS", false, "ecb", "Comma separated rate providers in priority order: ecb, file")
+var ecbURL = env.String("RATES_ECB_URL", false, data.ECBDailyURL, "ECB daily rates document")
+var historyURL = env.String("RATES_HISTORY_URL", false, data.ECBHistory90DaysURL, "ECB history document to load past rates from, empty for none")
+var ecbTimeout = env.Duration("RATES_ECB_TIMEOUT", false, 10*time.Second, "Timeout for requests to the ECB")
+var ratesPivot = env.String("RATES_PIVOT", false, "EUR", "Currency all rates are stored against, rates quoted against other bases are triangulated through it")
+var snapshotPath = env.String("RATES_SNAPSHOT_PATH", false, "./rates-snapshot.json", "File the last loaded rates are saved to, used when the providers are unreachable at startup")
+var retryInterval = env.Duration("RATES_RETRY_INTERVAL", false, 30*time.Second, "Interval to retry the providers at when started from saved rates")
+var ratesFile = env.String("RATES_FILE", false, "", "Rates file for the file provider, .xml (ECB), .csv (ECB) or .json")
+var simulation = env.String("RATES_SIMULATION", false, "random", "Simulated rate changes: random, or frozen for stable rates in tests")
+var simulationSeed = env.Int("RATES_SIMULATION_SEED", false, 0, "Seed for the simulated rate changes, 0 for a random seed")
+var simulationInterval = env.Duration("RATES_SIMULATION_INTERVAL", false, 5*time.Second, "Interval between simulated rate changes")
+var volatility = env.Float64("RATES_VOLATILITY", false, 0.1, "Largest simulated change of a rate, as a fraction")
+var subscriberQueue = env.Int("SUBSCRIBER_QUEUE_SIZE", false, server.DefaultQueueSize, "Rate updates buffered for each SubscribeRates stream")
+var slowSubscribers = env.String("SLOW_SUBSCRIBER_POLICY", false, "drop-oldest", "What to do when a stream's queue is full: drop-oldest, drop-newest or disconnect")
+var alertsWebhookURL = env.String("ALERTS_WEBHOOK_URL", false, "", "Webhook for alerts which do not have their own")
+var alertsWebhookSecret = env.String("ALERTS_WEBHOOK_SECRET", false, "", "Secret used to sign the alert webhook payloads")
+var alertsWebhookHosts = env.String("ALERTS_WEBHOOK_HOSTS", false, "", "Comma separated hosts, as host or host:port, alerts may post their own webhooks to")
+var httpBindAddress = env.String("HTTP_BIND_ADDRESS", false, ":9094", "Bind address for the HTTP/JSON server")
+var adminBindAddress = env.String("ADMIN_BIND_ADDRESS", false, "localhost:9096", "Bind address for the admin HTTP server, keep it off public interfaces")
+
 func main() {
 
-	// grpc server
-	gs := grpc.NewServer()
+	env.Parse()
 
 	// currency server contains ExchangeRates
-	rates, err := data.NewRates(hclog.Default())
+	rp, err := rateProvider(hclog.Default())
+	if err != nil {
+		log.Error("Unable to configure rate providers", "error", err)
+		os.Exit(1)
+	}
+	pivot := strings.ToUpper(*ratesPivot)
+	if !data.ValidCode(pivot) {
+		log.Error("Invalid pivot currency, expected an ISO 4217 code", "pivot", *ratesPivot)
+		os.Exit(1)
+	}
+	rates, err := data.NewRates(hclog.Default(), rp, pivot, data.NewSnapshotFile(*snapshotPath), *retryInterval)
 	if err != nil {
 		log.Error("unable to generate rates", "error", err)
 		os.Exit(1)
 	}
-	cs := server.NewCurrency(hclog.Default(), rates)
+	policy, err := server.ParseSlowConsumerPolicy(*slowSubscribers)
+	if err != nil {
+		log.Error("Unable to configure subscribers", "error", err)
+		os.Exit(1)
+	}
+	if *alertsWebhookSecret == "" {
+		hclog.Default().Warn("ALERTS_WEBHOOK_SECRET is not set, alert webhooks are sent unsigned and receivers cannot verify them")
+	}
+	// alert rules are evaluated on every rate update
+	al := alerts.New(hclog.Default().Named("alerts"), rates, alerts.NewWebhookNotifier(*alertsWebhookSecret), *alertsWebhookURL)
+	go al.Run(context.Background())
+
+	cs := server.NewCurrency(hclog.Default(), rates).
+		WithSubscriberQueue(*subscriberQueue, policy).
+		WithAlerts(al).
+		WithWebhookHosts(strings.Split(*alertsWebhookHosts, ","))
+
+	// the ECB only publishes once a day, simulate changes so rate streams have updates
+	sim, err := simulator()
+	if err != nil {
+		log.Error("Unable to configure rate simulation", "error", err)
+		os.Exit(1)
+	}
+	sim.Start(rates)
+	defer sim.Stop()
+
+	// grpc server, responses carry the source and age of the rates as metadata
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(server.RatesStatusUnaryInterceptor(rates)),
+		grpc.StreamInterceptor(server.RatesStatusStreamInterceptor(rates)),
+	)
 
 	// Register CurrencyServer with grpcServer & currencyServer instances
 	pb.RegisterCurrencyServer(gs, cs)
@@ -32,8 +103,63 @@ func main() {
 	// solution | Failed to list services: server does not support the reflection API
 	reflection.Register(gs)
 
+	// standard gRPC health checks, saved rates are still served so the service stays SERVING
+	hc := health.NewServer()
+	hc.SetServingStatus("pb.Currency", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(gs, hc)
+
+	// HTTP/JSON facade over the same currency server, for browsers and curl
+	rh := handlers.NewRates(hclog.Default().Named("http"), cs)
+	sm := mux.NewRouter()
+	sm.Use(handlers.RatesStatus(rates))
+	getR := sm.Methods(http.MethodGet).Subrouter()
+	getR.HandleFunc("/v1/rates", rh.GetRate)
+	getR.HandleFunc("/v1/rates/stream", rh.StreamRates)
+	getR.HandleFunc("/v1/convert", rh.Convert)
+	getR.HandleFunc("/v1/rates/history", rh.GetHistoricalRate)
+	getR.HandleFunc("/v1/rates/series", rh.GetRateSeries)
+	getR.HandleFunc("/v1/currencies", rh.ListCurrencies)
+	getR.Handle("/health", handlers.NewHealth(hclog.Default(), rates))
+
+	// no WriteTimeout as the stream endpoint keeps responses open
+	hs := &http.Server{
+		Addr:        *httpBindAddress,
+		Handler:     sm,
+		ErrorLog:    hclog.Default().StandardLogger(&hclog.StandardLoggerOptions{}),
+		ReadTimeout: 5 * time.Second,
+		IdleTimeout: 120 * time.Second,
+	}
+	go func() {
+		hclog.Default().Info("Starting HTTP server", "bind_address", *httpBindAddress)
+		err := hs.ListenAndServe()
+		if err != nil {
+			log.Error("Unable to start HTTP server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// admin endpoints expose the peer addresses of the streams, so they get their own listener
+	am := mux.NewRouter()
+	am.Methods(http.MethodGet).Path("/v1/subscriptions").HandlerFunc(rh.ListSubscriptions)
+	as := &http.Server{
+		Addr:         *adminBindAddress,
+		Handler:      am,
+		ErrorLog:     hclog.Default().StandardLogger(&hclog.StandardLoggerOptions{}),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	go func() {
+		hclog.Default().Info("Starting admin HTTP server", "bind_address", *adminBindAddress)
+		err := as.ListenAndServe()
+		if err != nil {
+			log.Error("Unable to start admin HTTP server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
 	// start a grpc server( grpcServer has a method Serve | similar to httpServer.ListenAndServe)
-	listener, err := net.Listen("tcp", ":9092")
+	listener, err := net.Listen("tcp", *bindAddress)
 	if err != nil {
 		log.Error("Unable to listen", "error", err)
 		os.Exit(1)
@@ -50,6 +176,37 @@ func main() {
 		grpcurl --plaintext localhost:9092 describe pb.RateRequest
 		grpcurl --plaintext localhost:9092 describe pb.RateResponse
 
+		-> Currency.ListCurrencies, codes are ISO 4217 strings validated against the rates of the provider
+		grpcurl --plaintext localhost:9092 pb.Currency.ListCurrencies
+		curl -v localhost:9094/v1/currencies | jq
+
+		-> HTTP/JSON facade
+		curl -v "localhost:9094/v1/rates?base=GBP&dest=INR" | jq
+		curl -N "localhost:9094/v1/rates/stream?base=GBP&dest=INR&dest=USD"
+		curl -v "localhost:9094/v1/convert?base=EUR&dest=JPY&amount=12.34&rounding=ROUND_HALF_UP" | jq
+
+		curl -v "localhost:9094/v1/rates/history?base=GBP&dest=INR&date=2023-03-10" | jq
+		curl -v "localhost:9094/v1/rates/series?base=GBP&dest=INR&start=2023-03-01&end=2023-03-10" | jq
+
+		-> Currency.GetHistoricalRate & Currency.GetRateSeries, full history with RATES_HISTORY_URL=https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist.xml
+		grpcurl --plaintext -d '{"Base":"GBP", "Destination":"INR", "Date":"2023-03-10"}' localhost:9092 pb.Currency.GetHistoricalRate
+		grpcurl --plaintext -d '{"Base":"GBP", "Destination":"INR", "Start":"2023-03-01", "End":"2023-03-10"}' localhost:9092 pb.Currency.GetRateSeries
+
+		-> source and age of the rates, "degraded" when started from the saved rates without internet
+		curl -v localhost:9094/health | jq
+		grpcurl --plaintext -d '{"service":"pb.Currency"}' localhost:9092 grpc.health.v1.Health/Check
+		grpcurl --plaintext -v -d '{"Base":"GBP", "Destination":"INR"}' localhost:9092 pb.Currency.GetRate
+
+		-> rates from the ECB with a local file as fallback
+		RATES_PROVIDERS=ecb,file RATES_FILE=./data/testdata/eurofxref-hist.csv go run main.go
+
+		-> stable or reproducible rates for tests
+		RATES_SIMULATION=frozen go run main.go
+		RATES_SIMULATION_SEED=42 RATES_VOLATILITY=0.01 RATES_SIMULATION_INTERVAL=1s go run main.go
+
+		-> Currency.Convert
+		grpcurl --plaintext -d '{"Base":"EUR", "Destination":"INR", "Amount":"12.34", "Rounding":"ROUND_HALF_UP"}' localhost:9092 pb.Currency.Convert
+
 		-> when base & destination of type string
 		grpcurl --plaintext -d '{"Base":"GBP", "Destination":"INR"}' localhost:9092 pb.Currency.GetRate
 
@@ -58,6 +215,20 @@ func main() {
 		*** @ means stdin with -d
 				now paste {"Base":"GBP", "Destination":"INR"} in stdin where you're getting RateResp every 5 sec
 
+		*** now paste {"Base":"GBP", "Destination":"INR", "Action":"UNSUBSCRIBE"} -> unsubscribed
+		*** now paste {"Base":"GBP", "Destination":"USD", "MinChange":0.05, "MinInterval":"30s"} -> moves of 5% or more, at most every 30s
+		curl -N "localhost:9094/v1/rates/stream?base=GBP&dest=INR&min_change=0.05&min_interval=30s"
+
+		-> Currency.ListSubscriptions, open streams & their pairs
+		grpcurl --plaintext localhost:9092 pb.Currency.ListSubscriptions
+		curl -v localhost:9096/v1/subscriptions | jq
+
+		-> Currency.CreateAlert, POSTs to the webhook signed with ALERTS_WEBHOOK_SECRET in X-Signature-256 when EUR->GBP rises to 0.9
+		ALERTS_WEBHOOK_SECRET=s3cret ALERTS_WEBHOOK_HOSTS=localhost:8080 go run main.go
+		grpcurl --plaintext -d '{"Alert":{"Base":"EUR", "Destination":"GBP", "Direction":"ABOVE", "Threshold":0.9, "Cooldown":"60s", "WebhookURL":"http://localhost:8080/alerts"}}' localhost:9092 pb.Currency.CreateAlert
+		grpcurl --plaintext localhost:9092 pb.Currency.ListAlerts
+		grpcurl --plaintext -d '{"ID":"alert-1"}' localhost:9092 pb.Currency.DeleteAlert
+
 		[SIMULATED CHANGING RATES]
 		-> grpcurl --plaintext localhost:9092 describe pb.RateResponse
 		*** now paste {"Base":"GBP", "Destination":"INR"} -> subscribed
@@ -65,3 +236,43 @@ func main() {
 				& so on...
 	*/
 }
+
+// simulator builds the Simulator configured by RATES_SIMULATION
+func simulator() (*data.Simulator, error) {
+	switch *simulation {
+	case "frozen":
+		return data.NewFrozenSimulator(), nil
+	case "random":
+		seed := int64(*simulationSeed)
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		hclog.Default().Info("Simulating rate changes", "seed", seed, "volatility", *volatility, "interval", *simulationInterval)
+		return data.NewSimulator(seed, *volatility, *simulationInterval), nil
+	}
+	return nil, fmt.Errorf("unknown rate simulation %q", *simulation)
+}
+
+// rateProvider builds the RateProvider configured by RATES_PROVIDERS, several
+// providers are combined so the later ones are used when the earlier ones fail
+func rateProvider(l hclog.Logger) (data.RateProvider, error) {
+	ps := []data.RateProvider{}
+	for _, name := range strings.Split(*providers, ",") {
+		switch strings.TrimSpace(name) {
+		case "ecb":
+			ps = append(ps, data.NewECBProvider(&http.Client{Timeout: *ecbTimeout}, *ecbURL, *historyURL))
+		case "file":
+			fp, err := data.NewFileProvider(*ratesFile)
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, fp)
+		default:
+			return nil, fmt.Errorf("unknown rate provider %q", name)
+		}
+	}
+	if len(ps) == 1 {
+		return ps[0], nil
+	}
+	return data.NewCompositeProvider(l.Named("rates"), ps...), nil
+}
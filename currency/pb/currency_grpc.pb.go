@@ -26,6 +26,26 @@ type CurrencyClient interface {
 	GetRate(ctx context.Context, in *RateRequest, opts ...grpc.CallOption) (*RateResponse, error)
 	// SubscribeRates
 	SubscribeRates(ctx context.Context, opts ...grpc.CallOption) (Currency_SubscribeRatesClient, error)
+	// GetRates returns the exchange rates from one base currency to many destination currencies
+	GetRates(ctx context.Context, in *RatesRequest, opts ...grpc.CallOption) (*RatesResponse, error)
+	// GetRateMatrix returns the exchange rates between every pair of the provided currencies
+	GetRateMatrix(ctx context.Context, in *RateMatrixRequest, opts ...grpc.CallOption) (*RateMatrixResponse, error)
+	// Convert converts an amount between two currencies, rounded to the minor unit of the destination
+	Convert(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (*ConvertResponse, error)
+	// GetHistoricalRate returns the exchange rate for the two provided currency codes on a past date
+	GetHistoricalRate(ctx context.Context, in *HistoricalRateRequest, opts ...grpc.CallOption) (*HistoricalRateResponse, error)
+	// GetRateSeries returns every exchange rate known for the two provided currency codes between two dates
+	GetRateSeries(ctx context.Context, in *RateSeriesRequest, opts ...grpc.CallOption) (*RateSeriesResponse, error)
+	// ListSubscriptions is an admin call returning the open SubscribeRates streams and their currency pairs
+	ListSubscriptions(ctx context.Context, in *ListSubscriptionsRequest, opts ...grpc.CallOption) (*ListSubscriptionsResponse, error)
+	// CreateAlert adds a rule which posts to a webhook when a rate crosses a threshold
+	CreateAlert(ctx context.Context, in *CreateAlertRequest, opts ...grpc.CallOption) (*Alert, error)
+	// ListAlerts returns all alert rules
+	ListAlerts(ctx context.Context, in *ListAlertsRequest, opts ...grpc.CallOption) (*ListAlertsResponse, error)
+	// DeleteAlert removes an alert rule and returns it
+	DeleteAlert(ctx context.Context, in *DeleteAlertRequest, opts ...grpc.CallOption) (*Alert, error)
+	// ListCurrencies returns the currencies the active rate provider has rates for
+	ListCurrencies(ctx context.Context, in *ListCurrenciesRequest, opts ...grpc.CallOption) (*ListCurrenciesResponse, error)
 }
 
 type currencyClient struct {
@@ -76,6 +96,96 @@ func (x *currencySubscribeRatesClient) Recv() (*StreamingRateResponse, error) {
 	return m, nil
 }
 
+func (c *currencyClient) GetRates(ctx context.Context, in *RatesRequest, opts ...grpc.CallOption) (*RatesResponse, error) {
+	out := new(RatesResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/GetRates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) GetRateMatrix(ctx context.Context, in *RateMatrixRequest, opts ...grpc.CallOption) (*RateMatrixResponse, error) {
+	out := new(RateMatrixResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/GetRateMatrix", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) Convert(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (*ConvertResponse, error) {
+	out := new(ConvertResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/Convert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) GetHistoricalRate(ctx context.Context, in *HistoricalRateRequest, opts ...grpc.CallOption) (*HistoricalRateResponse, error) {
+	out := new(HistoricalRateResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/GetHistoricalRate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) GetRateSeries(ctx context.Context, in *RateSeriesRequest, opts ...grpc.CallOption) (*RateSeriesResponse, error) {
+	out := new(RateSeriesResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/GetRateSeries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) ListSubscriptions(ctx context.Context, in *ListSubscriptionsRequest, opts ...grpc.CallOption) (*ListSubscriptionsResponse, error) {
+	out := new(ListSubscriptionsResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/ListSubscriptions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) CreateAlert(ctx context.Context, in *CreateAlertRequest, opts ...grpc.CallOption) (*Alert, error) {
+	out := new(Alert)
+	err := c.cc.Invoke(ctx, "/pb.Currency/CreateAlert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) ListAlerts(ctx context.Context, in *ListAlertsRequest, opts ...grpc.CallOption) (*ListAlertsResponse, error) {
+	out := new(ListAlertsResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/ListAlerts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) DeleteAlert(ctx context.Context, in *DeleteAlertRequest, opts ...grpc.CallOption) (*Alert, error) {
+	out := new(Alert)
+	err := c.cc.Invoke(ctx, "/pb.Currency/DeleteAlert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *currencyClient) ListCurrencies(ctx context.Context, in *ListCurrenciesRequest, opts ...grpc.CallOption) (*ListCurrenciesResponse, error) {
+	out := new(ListCurrenciesResponse)
+	err := c.cc.Invoke(ctx, "/pb.Currency/ListCurrencies", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CurrencyServer is the server API for Currency service.
 // All implementations must embed UnimplementedCurrencyServer
 // for forward compatibility
@@ -84,6 +194,26 @@ type CurrencyServer interface {
 	GetRate(context.Context, *RateRequest) (*RateResponse, error)
 	// SubscribeRates
 	SubscribeRates(Currency_SubscribeRatesServer) error
+	// GetRates returns the exchange rates from one base currency to many destination currencies
+	GetRates(context.Context, *RatesRequest) (*RatesResponse, error)
+	// GetRateMatrix returns the exchange rates between every pair of the provided currencies
+	GetRateMatrix(context.Context, *RateMatrixRequest) (*RateMatrixResponse, error)
+	// Convert converts an amount between two currencies, rounded to the minor unit of the destination
+	Convert(context.Context, *ConvertRequest) (*ConvertResponse, error)
+	// GetHistoricalRate returns the exchange rate for the two provided currency codes on a past date
+	GetHistoricalRate(context.Context, *HistoricalRateRequest) (*HistoricalRateResponse, error)
+	// GetRateSeries returns every exchange rate known for the two provided currency codes between two dates
+	GetRateSeries(context.Context, *RateSeriesRequest) (*RateSeriesResponse, error)
+	// ListSubscriptions is an admin call returning the open SubscribeRates streams and their currency pairs
+	ListSubscriptions(context.Context, *ListSubscriptionsRequest) (*ListSubscriptionsResponse, error)
+	// CreateAlert adds a rule which posts to a webhook when a rate crosses a threshold
+	CreateAlert(context.Context, *CreateAlertRequest) (*Alert, error)
+	// ListAlerts returns all alert rules
+	ListAlerts(context.Context, *ListAlertsRequest) (*ListAlertsResponse, error)
+	// DeleteAlert removes an alert rule and returns it
+	DeleteAlert(context.Context, *DeleteAlertRequest) (*Alert, error)
+	// ListCurrencies returns the currencies the active rate provider has rates for
+	ListCurrencies(context.Context, *ListCurrenciesRequest) (*ListCurrenciesResponse, error)
 	mustEmbedUnimplementedCurrencyServer()
 }
 
@@ -97,6 +227,36 @@ func (UnimplementedCurrencyServer) GetRate(context.Context, *RateRequest) (*Rate
 func (UnimplementedCurrencyServer) SubscribeRates(Currency_SubscribeRatesServer) error {
 	return status.Errorf(codes.Unimplemented, "method SubscribeRates not implemented")
 }
+func (UnimplementedCurrencyServer) GetRates(context.Context, *RatesRequest) (*RatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRates not implemented")
+}
+func (UnimplementedCurrencyServer) GetRateMatrix(context.Context, *RateMatrixRequest) (*RateMatrixResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRateMatrix not implemented")
+}
+func (UnimplementedCurrencyServer) Convert(context.Context, *ConvertRequest) (*ConvertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Convert not implemented")
+}
+func (UnimplementedCurrencyServer) GetHistoricalRate(context.Context, *HistoricalRateRequest) (*HistoricalRateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetHistoricalRate not implemented")
+}
+func (UnimplementedCurrencyServer) GetRateSeries(context.Context, *RateSeriesRequest) (*RateSeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRateSeries not implemented")
+}
+func (UnimplementedCurrencyServer) ListSubscriptions(context.Context, *ListSubscriptionsRequest) (*ListSubscriptionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSubscriptions not implemented")
+}
+func (UnimplementedCurrencyServer) CreateAlert(context.Context, *CreateAlertRequest) (*Alert, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAlert not implemented")
+}
+func (UnimplementedCurrencyServer) ListAlerts(context.Context, *ListAlertsRequest) (*ListAlertsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAlerts not implemented")
+}
+func (UnimplementedCurrencyServer) DeleteAlert(context.Context, *DeleteAlertRequest) (*Alert, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAlert not implemented")
+}
+func (UnimplementedCurrencyServer) ListCurrencies(context.Context, *ListCurrenciesRequest) (*ListCurrenciesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCurrencies not implemented")
+}
 func (UnimplementedCurrencyServer) mustEmbedUnimplementedCurrencyServer() {}
 
 // UnsafeCurrencyServer may be embedded to opt out of forward compatibility for this service.
@@ -154,6 +314,186 @@ func (x *currencySubscribeRatesServer) Recv() (*RateRequest, error) {
 	return m, nil
 }
 
+func _Currency_GetRates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).GetRates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/GetRates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).GetRates(ctx, req.(*RatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_GetRateMatrix_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RateMatrixRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).GetRateMatrix(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/GetRateMatrix",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).GetRateMatrix(ctx, req.(*RateMatrixRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_Convert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConvertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).Convert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/Convert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).Convert(ctx, req.(*ConvertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_GetHistoricalRate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HistoricalRateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).GetHistoricalRate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/GetHistoricalRate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).GetHistoricalRate(ctx, req.(*HistoricalRateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_GetRateSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RateSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).GetRateSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/GetRateSeries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).GetRateSeries(ctx, req.(*RateSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_ListSubscriptions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSubscriptionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).ListSubscriptions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/ListSubscriptions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).ListSubscriptions(ctx, req.(*ListSubscriptionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_CreateAlert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAlertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).CreateAlert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/CreateAlert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).CreateAlert(ctx, req.(*CreateAlertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_ListAlerts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAlertsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).ListAlerts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/ListAlerts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).ListAlerts(ctx, req.(*ListAlertsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_DeleteAlert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteAlertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).DeleteAlert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/DeleteAlert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).DeleteAlert(ctx, req.(*DeleteAlertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Currency_ListCurrencies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCurrenciesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CurrencyServer).ListCurrencies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Currency/ListCurrencies",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CurrencyServer).ListCurrencies(ctx, req.(*ListCurrenciesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Currency_ServiceDesc is the grpc.ServiceDesc for Currency service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -165,6 +505,46 @@ var Currency_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetRate",
 			Handler:    _Currency_GetRate_Handler,
 		},
+		{
+			MethodName: "GetRates",
+			Handler:    _Currency_GetRates_Handler,
+		},
+		{
+			MethodName: "GetRateMatrix",
+			Handler:    _Currency_GetRateMatrix_Handler,
+		},
+		{
+			MethodName: "Convert",
+			Handler:    _Currency_Convert_Handler,
+		},
+		{
+			MethodName: "GetHistoricalRate",
+			Handler:    _Currency_GetHistoricalRate_Handler,
+		},
+		{
+			MethodName: "GetRateSeries",
+			Handler:    _Currency_GetRateSeries_Handler,
+		},
+		{
+			MethodName: "ListSubscriptions",
+			Handler:    _Currency_ListSubscriptions_Handler,
+		},
+		{
+			MethodName: "CreateAlert",
+			Handler:    _Currency_CreateAlert_Handler,
+		},
+		{
+			MethodName: "ListAlerts",
+			Handler:    _Currency_ListAlerts_Handler,
+		},
+		{
+			MethodName: "DeleteAlert",
+			Handler:    _Currency_DeleteAlert_Handler,
+		},
+		{
+			MethodName: "ListCurrencies",
+			Handler:    _Currency_ListCurrencies_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
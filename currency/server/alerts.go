@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/satoshi-u/go-microservices/currency/alerts"
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// WithAlerts enables the alert RPCs, rules are kept and evaluated by a
+func (c *Currency) WithAlerts(a *alerts.Alerts) *Currency {
+	c.alerts = a
+	return c
+}
+
+// WithWebhookHosts sets the hosts alerts may post their own webhooks to, as host or
+// host:port. Without any, alerts can only use the default webhook.
+func (c *Currency) WithWebhookHosts(hosts []string) *Currency {
+	c.webhookHosts = map[string]bool{}
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" {
+			c.webhookHosts[h] = true
+		}
+	}
+	return c
+}
+
+// webhookAllowed returns true when the host of u is in the webhook hosts
+func (c *Currency) webhookAllowed(u *url.URL) bool {
+	return c.webhookHosts[strings.ToLower(u.Host)] || c.webhookHosts[strings.ToLower(u.Hostname())]
+}
+
+// alertDirections maps the API directions to alerts.Direction
+var alertDirections = map[pb.AlertDirection]alerts.Direction{
+	pb.AlertDirection_ABOVE: alerts.Above,
+	pb.AlertDirection_BELOW: alerts.Below,
+}
+
+// CreateAlert - validates and adds an alert rule
+func (c *Currency) CreateAlert(ctx context.Context, ar *pb.CreateAlertRequest) (*pb.Alert, error) {
+	c.log.Info("Handle CreateAlert", "alert", ar.GetAlert())
+	if c.alerts == nil {
+		return nil, status.Error(codes.Unimplemented, "alerts are not enabled")
+	}
+
+	a := ar.GetAlert()
+	if a == nil {
+		return nil, status.Error(codes.InvalidArgument, "Alert is required")
+	}
+	err := c.validateRateRequest(&pb.RateRequest{Base: a.GetBase(), Destination: a.GetDestination()})
+	if err != nil {
+		return nil, err
+	}
+	direction, ok := alertDirections[a.GetDirection()]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown Direction %d", a.GetDirection())
+	}
+	if a.GetThreshold() <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "Threshold must be positive, got %v", a.GetThreshold())
+	}
+	if cd := a.GetCooldown(); cd != nil && (cd.CheckValid() != nil || cd.AsDuration() < 0) {
+		return nil, status.Error(codes.InvalidArgument, "Cooldown must be a positive duration")
+	}
+	if a.GetWebhookURL() != "" {
+		u, err := url.Parse(a.GetWebhookURL())
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "WebhookURL must be an http or https URL, got %q", a.GetWebhookURL())
+		}
+		// the server posts to the URL, so only configured hosts are reachable through alerts
+		if !c.webhookAllowed(u) {
+			return nil, status.Errorf(codes.InvalidArgument, "WebhookURL host %q is not allowed", u.Host)
+		}
+	}
+
+	r, err := c.alerts.Create(alerts.Rule{
+		Base:        a.GetBase(),
+		Destination: a.GetDestination(),
+		Direction:   direction,
+		Threshold:   a.GetThreshold(),
+		Cooldown:    a.GetCooldown().AsDuration(),
+		WebhookURL:  a.GetWebhookURL(),
+	})
+	if err == alerts.ErrNoWebhook {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to create alert: %s", err)
+	}
+	return toAlert(r), nil
+}
+
+// ListAlerts - returns all alert rules
+func (c *Currency) ListAlerts(ctx context.Context, lr *pb.ListAlertsRequest) (*pb.ListAlertsResponse, error) {
+	c.log.Info("Handle ListAlerts")
+	if c.alerts == nil {
+		return nil, status.Error(codes.Unimplemented, "alerts are not enabled")
+	}
+
+	resp := &pb.ListAlertsResponse{Alerts: []*pb.Alert{}}
+	for _, r := range c.alerts.List() {
+		resp.Alerts = append(resp.Alerts, toAlert(r))
+	}
+	return resp, nil
+}
+
+// DeleteAlert - removes an alert rule and returns it
+func (c *Currency) DeleteAlert(ctx context.Context, dr *pb.DeleteAlertRequest) (*pb.Alert, error) {
+	c.log.Info("Handle DeleteAlert", "id", dr.GetID())
+	if c.alerts == nil {
+		return nil, status.Error(codes.Unimplemented, "alerts are not enabled")
+	}
+
+	r, err := c.alerts.Delete(dr.GetID())
+	if err == alerts.ErrRuleNotFound {
+		return nil, status.Errorf(codes.NotFound, "alert %q not found", dr.GetID())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "unable to delete alert: %s", err)
+	}
+	return toAlert(r), nil
+}
+
+func toAlert(r alerts.Rule) *pb.Alert {
+	a := &pb.Alert{
+		ID:          r.ID,
+		Base:        r.Base,
+		Destination: r.Destination,
+		Direction:   pb.AlertDirection(r.Direction),
+		Threshold:   r.Threshold,
+		Cooldown:    durationpb.New(r.Cooldown),
+		WebhookURL:  r.WebhookURL,
+	}
+	if !r.LastTriggered.IsZero() {
+		a.LastTriggered = timestamppb.New(r.LastTriggered)
+	}
+	return a
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/alerts"
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func newTestAlerts(t *testing.T, defaultURL string) *Currency {
+	c, er := newTestCurrency(t)
+	return c.WithAlerts(alerts.New(hclog.NewNullLogger(), er, alerts.NewWebhookNotifier("s3cret"), defaultURL)).
+		WithWebhookHosts([]string{"example.com", "localhost:8080"})
+}
+
+func TestCreateAlertValidation(t *testing.T) {
+	c := newTestAlerts(t, "")
+	valid := func() *pb.Alert {
+		return &pb.Alert{Base: "EUR", Destination: "GBP", Direction: pb.AlertDirection_ABOVE, Threshold: 0.9,
+			Cooldown: durationpb.New(time.Minute), WebhookURL: "https://example.com/hook"}
+	}
+
+	tests := map[string]func(a *pb.Alert){
+		"zero threshold":     func(a *pb.Alert) { a.Threshold = 0 },
+		"negative threshold": func(a *pb.Alert) { a.Threshold = -1 },
+		"negative cooldown":  func(a *pb.Alert) { a.Cooldown = durationpb.New(-time.Second) },
+		"invalid cooldown":   func(a *pb.Alert) { a.Cooldown = &durationpb.Duration{Seconds: 1, Nanos: -1} },
+		"ftp url":            func(a *pb.Alert) { a.WebhookURL = "ftp://example.com/hook" },
+		"relative url":       func(a *pb.Alert) { a.WebhookURL = "/hook" },
+		"unparsable url":     func(a *pb.Alert) { a.WebhookURL = "http://[::1" },
+		"unknown currency":   func(a *pb.Alert) { a.Destination = "HRK" },
+		"no url or default":  func(a *pb.Alert) { a.WebhookURL = "" },
+		"unknown direction":  func(a *pb.Alert) { a.Direction = pb.AlertDirection(7) },
+		"host not allowed":   func(a *pb.Alert) { a.WebhookURL = "http://169.254.169.254/latest" },
+		"port not allowed":   func(a *pb.Alert) { a.WebhookURL = "http://localhost:9092/hook" },
+	}
+	for name, change := range tests {
+		a := valid()
+		change(a)
+		_, err := c.CreateAlert(context.Background(), &pb.CreateAlertRequest{Alert: a})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+
+	_, err := c.CreateAlert(context.Background(), &pb.CreateAlertRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	a, err := c.CreateAlert(context.Background(), &pb.CreateAlertRequest{Alert: valid()})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, a.GetCooldown().AsDuration())
+	assert.Equal(t, "https://example.com/hook", a.GetWebhookURL())
+
+	b := valid()
+	b.Direction = pb.AlertDirection_BELOW
+	b.WebhookURL = "http://localhost:8080/alerts"
+	a, err = c.CreateAlert(context.Background(), &pb.CreateAlertRequest{Alert: b})
+	assert.NoError(t, err)
+	assert.Equal(t, pb.AlertDirection_BELOW, a.GetDirection())
+}
+
+func TestCreateAlertUsesDefaultWebhook(t *testing.T) {
+	c := newTestAlerts(t, "https://example.com/default")
+
+	// no cooldown and no URL are both allowed with a default webhook
+	a, err := c.CreateAlert(context.Background(), &pb.CreateAlertRequest{Alert: &pb.Alert{Base: "EUR", Destination: "GBP", Threshold: 0.9}})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), a.GetCooldown().AsDuration())
+
+	resp, err := c.ListAlerts(context.Background(), &pb.ListAlertsRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetAlerts(), 1)
+
+	_, err = c.DeleteAlert(context.Background(), &pb.DeleteAlertRequest{ID: a.GetID()})
+	assert.NoError(t, err)
+	_, err = c.DeleteAlert(context.Background(), &pb.DeleteAlertRequest{ID: a.GetID()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAlertsDisabled(t *testing.T) {
+	c, _ := newTestCurrency(t)
+	_, err := c.ListAlerts(context.Background(), &pb.ListAlertsRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
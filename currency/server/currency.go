@@ -2,153 +2,574 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log"
+	"math/big"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/alerts"
 	"github.com/satoshi-u/go-microservices/currency/data"
 	"github.com/satoshi-u/go-microservices/currency/pb"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // implements CurrencyServer
 type Currency struct {
-	log           hclog.Logger
-	rates         *data.ExchangeRates
-	subscriptions map[pb.Currency_SubscribeRatesServer][]*pb.RateRequest
+	log   hclog.Logger
+	rates *data.ExchangeRates
+
+	// mu guards subscriptions, the subscribers guard their own requests
+	mu            sync.RWMutex
+	subscriptions map[pb.Currency_SubscribeRatesServer]*subscriber
+	queueSize     int
+	policy        SlowConsumerPolicy
+	lastID        uint64
+
+	// alerts is nil unless enabled with WithAlerts
+	alerts *alerts.Alerts
+	// webhookHosts are the hosts alerts may post their own webhooks to
+	webhookHosts map[string]bool
+
 	*pb.UnimplementedCurrencyServer
 }
 
 // NewCurrency - gives back a currency server
 func NewCurrency(l hclog.Logger, er *data.ExchangeRates) *Currency {
-	c := &Currency{l, er, make(map[pb.Currency_SubscribeRatesServer][]*pb.RateRequest), &pb.UnimplementedCurrencyServer{}}
+	c := &Currency{
+		log:                         l,
+		rates:                       er,
+		subscriptions:               make(map[pb.Currency_SubscribeRatesServer]*subscriber),
+		queueSize:                   DefaultQueueSize,
+		policy:                      DropOldest,
+		UnimplementedCurrencyServer: &pb.UnimplementedCurrencyServer{},
+	}
 	go c.handleUpdates()
 	return c
 }
 
-// handleUpdates
+// WithSubscriberQueue sets the number of updates buffered for each SubscribeRates stream
+// and what happens when a stream does not keep up, it applies to streams opened afterwards
+func (c *Currency) WithSubscriberQueue(size int, policy SlowConsumerPolicy) *Currency {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queueSize = size
+	c.policy = policy
+	return c
+}
+
+// handleUpdates queues the changed rates for every subscriber, it never blocks on a stream
 func (c *Currency) handleUpdates() {
-	ru := c.rates.MonitorRates(5 * time.Second)
+	ru := c.rates.MonitorRates()
 	for range ru {
 		c.log.Info("Got updated rates")
 		// loop over subscribed clients
-		for client, subscription := range c.subscriptions {
-			// loop over rates for a specific client
-			for _, rr := range subscription {
-				r, err := c.rates.GetRate(rr.GetBase().String(), rr.GetDestination().String())
-				if err != nil {
-					c.log.Error("Unable to get updated rates", "base", rr.GetBase(), "destination", rr.GetDestination())
-				}
-				err = client.Send(&pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_RateResponse{RateResponse: &pb.RateResponse{Base: rr.GetBase(), Destination: rr.GetDestination(), Rate: r}}}) // @gRPC stream{server -> client}
-				if err != nil {
-					c.log.Error("Unable to send updated rates", "base", rr.GetBase(), "destination", rr.GetDestination())
+		now := time.Now()
+		for _, sub := range c.subscribers() {
+			// rates for a specific client which moved enough since its last update
+			ms, err := sub.updates(now)
+			if err != nil {
+				c.log.Error("Unable to get updated rates", "id", sub.id, "error", err)
+			}
+			keepingUp := true
+			for _, m := range ms {
+				if !sub.enqueue(m) {
+					keepingUp = false
 				}
 			}
+			if !keepingUp {
+				c.log.Warn("Subscriber is not keeping up", "id", sub.id, "policy", sub.policy, "dropped", sub.droppedUpdates())
+			}
 		}
 	}
 }
 
+// subscribers returns the currently registered subscribers
+func (c *Currency) subscribers() []*subscriber {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	subs := make([]*subscriber, 0, len(c.subscriptions))
+	for _, sub := range c.subscriptions {
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+// register adds a subscriber for the stream
+func (c *Currency) register(src pb.Currency_SubscribeRatesServer) *subscriber {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastID++
+	sub := newSubscriber(src, c.queueSize, c.policy)
+	sub.id = fmt.Sprintf("sub-%d", c.lastID)
+	sub.rate = func(rr *pb.RateRequest) (float64, error) {
+		return c.rates.GetRate(rr.GetBase(), rr.GetDestination())
+	}
+	if p, ok := peer.FromContext(src.Context()); ok {
+		sub.peer = p.Addr.String()
+	}
+	c.subscriptions[src] = sub
+	c.log.Info("Client subscribed", "id", sub.id, "peer", sub.peer)
+	return sub
+}
+
+// unregister removes the subscriber of the stream
+func (c *Currency) unregister(src pb.Currency_SubscribeRatesServer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if sub, ok := c.subscriptions[src]; ok {
+		sub.stop()
+		c.log.Info("Client unsubscribed", "id", sub.id, "subscriptions", len(sub.subscriptions()))
+	}
+	delete(c.subscriptions, src)
+}
+
 // GetRate - calls the underlying data.ExchangeRates with RateRequest values to get & return a valid RateResponse
 func (c *Currency) GetRate(ctx context.Context, rr *pb.RateRequest) (*pb.RateResponse, error) {
 	c.log.Info("Handle GetRate", "base", rr.GetBase(), "destination", rr.GetDestination())
 
-	// validation - gRPC Error messages in Unary RPCs - at server side
-	if rr.Base == rr.Destination {
-		// return nil, fmt.Errorf("error : Base can not be the same as destination")
-		// return nil, status.Errorf(
-		// 	codes.InvalidArgument,
-		// 	"Base currency %s can not be the same as destination currency %s",
-		// 	rr.Base.String(),
-		// 	rr.Destination.String(),
-		// )
-		err := status.Newf(
-			codes.InvalidArgument,
-			"Base currency %s can not be the same as destination currency %s",
-			rr.Base.String(),
-			rr.Destination.String(),
-		)
-		err, wdErr := err.WithDetails(rr)
-		if wdErr != nil {
-			return nil, wdErr
-		}
-		return nil, err.Err()
+	err := c.validateRateRequest(rr)
+	if err != nil {
+		return nil, err
 	}
 
-	rate, err := c.rates.GetRate(rr.GetBase().String(), rr.GetDestination().String())
+	rate, err := c.rates.GetRate(rr.GetBase(), rr.GetDestination())
 	if err != nil {
 		return nil, err
 	}
-	log.Println("base rate: ", rr.GetBase().String())
-	log.Println("destination rate: ", rr.GetDestination().String())
+	log.Println("base rate: ", rr.GetBase())
+	log.Println("destination rate: ", rr.GetDestination())
 	log.Println("rate: ", rate)
 	return &pb.RateResponse{Base: rr.Base, Destination: rr.Destination, Rate: rate}, nil
 }
 
-// SubscribeRates - starts sending const RateResponse in never ending loop to a client who calls -> GRPC pb.Currency.SubscribeRates
-//								- starts receiving RateRequest in never ending loop to a client when client writes in stdin of called GRPC pb.Currency.SubscribeRates
-func (c *Currency) SubscribeRates(src pb.Currency_SubscribeRatesServer) error {
-	// inbound from client - handle client messages
-	for {
-		// Recv is a blocking method which returns on client data
-		rr, err := src.Recv() // @gRPC stream{server <- client}
-		if err == io.EOF {
-			c.log.Info("Client has closed connection")
-			break
+// validateRateRequest - checks the currencies of a rate request are known and can be converted
+func (c *Currency) validateRateRequest(rr *pb.RateRequest) error {
+	// validation - gRPC Error messages in Unary RPCs - at server side
+	for _, f := range []struct{ field, code string }{{"base", rr.GetBase()}, {"destination", rr.GetDestination()}} {
+		if !data.ValidCode(f.code) || !c.rates.HasCurrency(f.code) {
+			return rateRequestError(rr, "Unknown %s currency %q, see ListCurrencies for the supported ones", f.field, f.code)
+		}
+	}
+	if rr.Base == rr.Destination {
+		return rateRequestError(rr, "Base currency %s can not be the same as destination currency %s", rr.Base, rr.Destination)
+	}
+	return nil
+}
+
+// rateRequestError creates an InvalidArgument error with the request as details
+func rateRequestError(rr *pb.RateRequest, format string, a ...interface{}) error {
+	err := status.Newf(codes.InvalidArgument, format, a...)
+	err, wdErr := err.WithDetails(rr)
+	if wdErr != nil {
+		return wdErr
+	}
+	return err.Err()
+}
+
+// ListCurrencies - returns the currencies with a rate, with their names and minor units
+func (c *Currency) ListCurrencies(ctx context.Context, lr *pb.ListCurrenciesRequest) (*pb.ListCurrenciesResponse, error) {
+	c.log.Info("Handle ListCurrencies")
+
+	resp := &pb.ListCurrenciesResponse{Currencies: []*pb.CurrencyInfo{}}
+	for _, code := range c.rates.Currencies() {
+		ci := data.Currency(code)
+		resp.Currencies = append(resp.Currencies, &pb.CurrencyInfo{Code: ci.Code, Name: ci.Name, MinorUnits: int32(ci.MinorUnits)})
+	}
+	return resp, nil
+}
+
+// GetRates - returns the rates from one base currency to many destinations in a single call,
+// every destination is validated in the same way as GetRate
+func (c *Currency) GetRates(ctx context.Context, rr *pb.RatesRequest) (*pb.RatesResponse, error) {
+	c.log.Info("Handle GetRates", "base", rr.GetBase(), "destinations", rr.GetDestinations())
+
+	dests := rr.GetDestinations()
+	if len(dests) == 0 {
+		dests = c.knownCurrencies(rr.GetBase())
+	}
+
+	resp := &pb.RatesResponse{Base: rr.GetBase()}
+	for _, d := range dests {
+		req := &pb.RateRequest{Base: rr.GetBase(), Destination: d}
+		err := c.validateRateRequest(req)
+		if err != nil {
+			return nil, err
 		}
-		// any other err - transport between the server and client is unavailable
+		rate, err := c.rates.GetRate(req.GetBase(), req.GetDestination())
 		if err != nil {
-			c.log.Error("Unable to read from client", "error", err)
-			return err
+			return nil, err
 		}
+		resp.Rates = append(resp.Rates, &pb.RateResponse{Base: req.Base, Destination: req.Destination, Rate: rate})
+	}
+	return resp, nil
+}
 
-		// time.Sleep(5 * time.Second)
-		c.log.Info("Handle client subscribe request", "rate-request", rr, "request_base", rr.GetBase(), "request_dest", rr.GetDestination())
+// GetRateMatrix - returns the rates between every pair of the requested currencies,
+// each row holds the rates from one currency to all of the others
+func (c *Currency) GetRateMatrix(ctx context.Context, rm *pb.RateMatrixRequest) (*pb.RateMatrixResponse, error) {
+	c.log.Info("Handle GetRateMatrix", "currencies", rm.GetCurrencies())
 
-		rrs, ok := c.subscriptions[src]
-		if !ok {
-			rrs = []*pb.RateRequest{}
+	curs := rm.GetCurrencies()
+	if len(curs) == 0 {
+		curs = c.knownCurrencies("")
+	}
+
+	resp := &pb.RateMatrixResponse{}
+	for _, base := range curs {
+		dests := []string{}
+		for _, d := range curs {
+			if d != base {
+				dests = append(dests, d)
+			}
 		}
+		row, err := c.GetRates(ctx, &pb.RatesRequest{Base: base, Destinations: dests})
+		if err != nil {
+			return nil, err
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp, nil
+}
 
-		// check that subscription does not exist - origin @ gRPC Error messages in gRPC bi-directional stream { at server side }
-		var validationError *status.Status
-		for _, v := range rrs {
-			if v.Base == rr.Base && v.Destination == rr.Destination {
-				// subscription exists, return error
-				c.log.Error("Subscription already active", "base", rr.Base.String(), "dest", rr.Destination.String())
-				validationError = status.Newf(
-					codes.AlreadyExists,
-					"Unable to subscribe for currency as subscription already exists for rate",
-				)
-				// add the original request as metadata
-				validationError, err = validationError.WithDetails(rr)
-				if err != nil {
-					c.log.Error("unable to add metadata to error", "error", err)
-					break
-				}
-				break
+// Convert - converts an amount between two currencies with the current rate, the
+// result is rounded to the minor units of the destination currency
+func (c *Currency) Convert(ctx context.Context, cr *pb.ConvertRequest) (*pb.ConvertResponse, error) {
+	c.log.Info("Handle Convert", "base", cr.GetBase(), "destination", cr.GetDestination(), "amount", cr.GetAmount(), "rounding", cr.GetRounding())
+
+	rr := &pb.RateRequest{Base: cr.GetBase(), Destination: cr.GetDestination()}
+	err := c.validateRateRequest(rr)
+	if err != nil {
+		return nil, err
+	}
+
+	rounding, ok := roundingModes[cr.GetRounding()]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown rounding mode %d", cr.GetRounding())
+	}
+
+	var amount *big.Rat
+	if cr.GetAmount() != "" {
+		amount, err = data.ParseDecimal(cr.GetAmount())
+	} else {
+		amount, err = data.FromUnitsNanos(cr.GetUnits(), cr.GetNanos())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rate, err := c.rates.GetRate(rr.GetBase(), rr.GetDestination())
+	if err != nil {
+		return nil, err
+	}
+
+	dest := rr.GetDestination()
+	converted := data.Convert(amount, rate, dest, rounding)
+	units, nanos := data.ToUnitsNanos(converted)
+	return &pb.ConvertResponse{
+		Base:        cr.GetBase(),
+		Destination: cr.GetDestination(),
+		Amount:      converted.FloatString(data.MinorUnits(dest)),
+		Units:       units,
+		Nanos:       nanos,
+		Rate:        rate,
+	}, nil
+}
+
+// GetHistoricalRate - returns the rate for two currencies on a past date
+func (c *Currency) GetHistoricalRate(ctx context.Context, hr *pb.HistoricalRateRequest) (*pb.HistoricalRateResponse, error) {
+	c.log.Info("Handle GetHistoricalRate", "base", hr.GetBase(), "destination", hr.GetDestination(), "date", hr.GetDate())
+
+	err := c.validateRateRequest(&pb.RateRequest{Base: hr.GetBase(), Destination: hr.GetDestination()})
+	if err != nil {
+		return nil, err
+	}
+	date, err := parseDate("Date", hr.GetDate())
+	if err != nil {
+		return nil, err
+	}
+
+	rate, t, err := c.rates.GetHistoricalRate(hr.GetBase(), hr.GetDestination(), date)
+	if err == data.ErrNoHistory {
+		return nil, status.Errorf(codes.NotFound, "no rates available on or before %s", hr.GetDate())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.HistoricalRateResponse{Base: hr.GetBase(), Destination: hr.GetDestination(), Rate: rate, Time: timestamppb.New(t)}, nil
+}
+
+// GetRateSeries - returns the known rates for two currencies between two dates
+func (c *Currency) GetRateSeries(ctx context.Context, sr *pb.RateSeriesRequest) (*pb.RateSeriesResponse, error) {
+	c.log.Info("Handle GetRateSeries", "base", sr.GetBase(), "destination", sr.GetDestination(), "start", sr.GetStart(), "end", sr.GetEnd())
+
+	err := c.validateRateRequest(&pb.RateRequest{Base: sr.GetBase(), Destination: sr.GetDestination()})
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseDate("Start", sr.GetStart())
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseDate("End", sr.GetEnd())
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, status.Errorf(codes.InvalidArgument, "End %s is before Start %s", sr.GetEnd(), sr.GetStart())
+	}
+
+	ps, err := c.rates.GetRateSeries(sr.GetBase(), sr.GetDestination(), start, end)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.RateSeriesResponse{Base: sr.GetBase(), Destination: sr.GetDestination()}
+	for _, p := range ps {
+		resp.Points = append(resp.Points, &pb.RatePoint{Time: timestamppb.New(p.Time), Rate: p.Rate})
+	}
+	return resp, nil
+}
+
+// parseDate parses a YYYY-MM-DD date field of a request
+func parseDate(field, value string) (time.Time, error) {
+	t, err := time.Parse(data.DateFormat, value)
+	if err != nil {
+		return time.Time{}, status.Errorf(codes.InvalidArgument, "%s must be a date as YYYY-MM-DD, got %q", field, value)
+	}
+	return t, nil
+}
+
+// roundingModes maps the API rounding modes to data.RoundingMode, unspecified is half even
+var roundingModes = map[pb.RoundingMode]data.RoundingMode{
+	pb.RoundingMode_ROUNDING_MODE_UNSPECIFIED: data.RoundHalfEven,
+	pb.RoundingMode_ROUND_HALF_EVEN:           data.RoundHalfEven,
+	pb.RoundingMode_ROUND_HALF_UP:             data.RoundHalfUp,
+	pb.RoundingMode_ROUND_HALF_DOWN:           data.RoundHalfDown,
+	pb.RoundingMode_ROUND_UP:                  data.RoundUp,
+	pb.RoundingMode_ROUND_DOWN:                data.RoundDown,
+	pb.RoundingMode_ROUND_CEILING:             data.RoundCeiling,
+	pb.RoundingMode_ROUND_FLOOR:               data.RoundFloor,
+}
+
+// knownCurrencies returns the currencies which have a rate, except the given one
+func (c *Currency) knownCurrencies(except string) []string {
+	curs := []string{}
+	for _, code := range c.rates.Currencies() {
+		if code != except {
+			curs = append(curs, code)
+		}
+	}
+	return curs
+}
+
+// SubscribeRates - starts sending const RateResponse in never ending loop to a client who calls -> GRPC pb.Currency.SubscribeRates
+//   - starts receiving RateRequest in never ending loop to a client when client writes in stdin of called GRPC pb.Currency.SubscribeRates
+//   - a RateRequest with Action UNSUBSCRIBE stops the updates for its pair, all subscriptions end with the stream
+func (c *Currency) SubscribeRates(src pb.Currency_SubscribeRatesServer) error {
+	sub := c.register(src)
+
+	// outbound to client - a single writer goroutine sends the queued updates and
+	// replies, so a client which stops reading only blocks its own writer
+	replies := make(chan *pb.StreamingRateResponse)
+	sendErr := make(chan error, 1)
+	quit := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for {
+			var m *pb.StreamingRateResponse
+			select {
+			case <-quit:
+				return
+			case m = <-sub.queue:
+			case m = <-replies:
+			}
+			// select picks at random among ready cases, so a message may have been
+			// taken after the handler started returning, Send must not be called then
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			err := src.Send(m) // @gRPC stream{server -> client}
+			if err != nil {
+				sendErr <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		// no more updates are queued for the subscriber once it is unregistered
+		c.unregister(src)
+		close(quit)
+		// gRPC does not allow Send after the handler returns, so the writer is always
+		// waited for, a Send blocked on a client which stopped reading returns when
+		// the client goes away or its connection is closed
+		<-writerDone
+	}()
+
+	// inbound from client - Recv blocks, so it runs on its own goroutine and hands the requests over
+	type recv struct {
+		rr  *pb.RateRequest
+		err error
+	}
+	reqs := make(chan recv)
+	go func() {
+		for {
+			// Recv is a blocking method which returns on client data
+			rr, err := src.Recv() // @gRPC stream{server <- client}
+			select {
+			case reqs <- recv{rr, err}:
+			case <-src.Context().Done():
+				return
+			}
+			if err != nil {
+				return
 			}
 		}
+	}()
+
+	for {
+		select {
+		case <-src.Context().Done():
+			c.log.Info("Client has gone away")
+			return nil
+
+		case <-sub.slow:
+			c.log.Error("Disconnecting slow subscriber", "queue_size", cap(sub.queue))
+			return status.Errorf(codes.ResourceExhausted, "subscriber did not keep up with %d queued rate updates", cap(sub.queue))
+
+		case err := <-sendErr:
+			c.log.Error("Unable to send updated rates", "error", err)
+			return err
 
-		// if a validation error, return error and continue
-		if validationError != nil {
-			src.Send(&pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_Error{Error: validationError.Proto()}})
-			continue
+		case r := <-reqs:
+			if r.err == io.EOF {
+				c.log.Info("Client has closed connection")
+				return nil
+			}
+			// any other err - transport between the server and client is unavailable
+			if r.err != nil {
+				c.log.Error("Unable to read from client", "error", r.err)
+				return r.err
+			}
+
+			m := c.handleRequest(sub, r.rr)
+			if m == nil {
+				continue
+			}
+			select {
+			case replies <- m:
+			case <-sub.slow:
+			case <-src.Context().Done():
+			}
 		}
+	}
+}
+
+// handleRequest subscribes or unsubscribes the pair of a request, it returns an
+// error message for the stream when the request can not be applied
+func (c *Currency) handleRequest(sub *subscriber, rr *pb.RateRequest) *pb.StreamingRateResponse {
+	c.log.Info("Handle client subscription request", "id", sub.id, "action", rr.GetAction(), "request_base", rr.GetBase(), "request_dest", rr.GetDestination())
+
+	var err error
+	if rr.GetAction() == pb.SubscriptionAction_UNSUBSCRIBE {
+		err = c.unsubscribe(sub, rr)
+	} else {
+		err = c.subscribe(sub, rr)
+	}
+	if err == nil {
+		return nil
+	}
+	return &pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_Error{Error: status.Convert(err).Proto()}}
+}
+
+// subscribe adds a subscription to the subscriber
+func (c *Currency) subscribe(sub *subscriber, rr *pb.RateRequest) error {
+	err := c.validateRateRequest(rr)
+	if err != nil {
+		return err
+	}
+	if rr.GetMinChange() < 0 {
+		return status.Errorf(codes.InvalidArgument, "MinChange must not be negative, got %v", rr.GetMinChange())
+	}
+	if mi := rr.GetMinInterval(); mi != nil && (mi.CheckValid() != nil || mi.AsDuration() < 0) {
+		return status.Errorf(codes.InvalidArgument, "MinInterval must be a positive duration")
+	}
+	// changes are measured from the rate at the time of subscribing
+	rate, err := c.rates.GetRate(rr.GetBase(), rr.GetDestination())
+	if err != nil {
+		return status.Errorf(codes.NotFound, "%s", err)
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
 
-		// ok : appends only if no validation error
-		rrs = append(rrs, rr)
-		c.subscriptions[src] = rrs
-	}
-	// outbound to client - handle server responses
-	// we block here to keep the connection open
-	// for {
-	// 	err := src.Send(&pb.RateResponse{Rate: 1.0})
-	// 	if err != nil {
-	// 		return err
-	// 	}
-	// 	time.Sleep(5 * time.Second)
-	// }
+	// check that subscription does not exist - origin @ gRPC Error messages in gRPC bi-directional stream { at server side }
+	if sub.find(rr) >= 0 {
+		// subscription exists, return error
+		c.log.Error("Subscription already active", "base", rr.Base, "dest", rr.Destination)
+		return requestError(codes.AlreadyExists, "Unable to subscribe for currency as subscription already exists for rate", rr)
+	}
+
+	// ok : appends only if no validation error
+	sub.requests = append(sub.requests, &subscription{
+		req:      &pb.RateRequest{Base: rr.GetBase(), Destination: rr.GetDestination(), MinChange: rr.GetMinChange(), MinInterval: rr.GetMinInterval()},
+		last:     rate,
+		lastSent: time.Now(),
+	})
 	return nil
 }
+
+// unsubscribe removes a subscription from the subscriber
+func (c *Currency) unsubscribe(sub *subscriber, rr *pb.RateRequest) error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	i := sub.find(rr)
+	if i < 0 {
+		c.log.Error("Subscription not active", "base", rr.Base, "dest", rr.Destination)
+		return requestError(codes.NotFound, "Unable to unsubscribe for currency as there is no subscription for rate", rr)
+	}
+	sub.requests[i].stop()
+	sub.requests = append(sub.requests[:i], sub.requests[i+1:]...)
+	return nil
+}
+
+// requestError creates a status error with the original request as details
+func requestError(code codes.Code, msg string, rr *pb.RateRequest) error {
+	s := status.New(code, msg)
+	// add the original request as metadata
+	sd, err := s.WithDetails(rr)
+	if err != nil {
+		return s.Err()
+	}
+	return sd.Err()
+}
+
+// ListSubscriptions - returns the open SubscribeRates streams, oldest first
+func (c *Currency) ListSubscriptions(ctx context.Context, lr *pb.ListSubscriptionsRequest) (*pb.ListSubscriptionsResponse, error) {
+	c.log.Info("Handle ListSubscriptions")
+
+	subs := c.subscribers()
+	sort.Slice(subs, func(i, j int) bool { return subs[i].since.Before(subs[j].since) })
+
+	resp := &pb.ListSubscriptionsResponse{Subscriptions: []*pb.Subscription{}}
+	for _, sub := range subs {
+		resp.Subscriptions = append(resp.Subscriptions, &pb.Subscription{
+			ID:      sub.id,
+			Peer:    sub.peer,
+			Since:   timestamppb.New(sub.since),
+			Pairs:   sub.subscriptions(),
+			Queued:  int32(len(sub.queue)),
+			Dropped: int64(sub.droppedUpdates()),
+		})
+	}
+	return resp, nil
+}
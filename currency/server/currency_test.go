@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCurrenciesAreValidatedAgainstTheRates(t *testing.T) {
+	c, _ := newTestCurrency(t)
+
+	_, err := c.GetRate(context.Background(), &pb.RateRequest{Base: "GBP", Destination: "USD"})
+	assert.NoError(t, err)
+
+	// HRK is a valid code but the rates do not have it
+	for _, rr := range []*pb.RateRequest{{Base: "GBP", Destination: "HRK"}, {Base: "gbp", Destination: "USD"}, {Base: "GBP"}, {Base: "GBP", Destination: "GBP"}} {
+		_, err = c.GetRate(context.Background(), rr)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", rr)
+	}
+
+	resp, err := c.ListCurrencies(context.Background(), &pb.ListCurrenciesRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Currencies, 4)
+	assert.Equal(t, &pb.CurrencyInfo{Code: "EUR", Name: "Euro", MinorUnits: 2}, resp.Currencies[0])
+}
+
+func TestGetRates(t *testing.T) {
+	c, _ := newTestCurrency(t)
+
+	// every other currency when no destinations are given
+	resp, err := c.GetRates(context.Background(), &pb.RatesRequest{Base: "EUR"})
+	assert.NoError(t, err)
+	dests := []string{}
+	for _, r := range resp.GetRates() {
+		assert.Equal(t, "EUR", r.GetBase())
+		dests = append(dests, r.GetDestination())
+	}
+	assert.Equal(t, []string{"GBP", "INR", "USD"}, dests)
+	assert.Equal(t, 1.0581, resp.GetRates()[2].GetRate())
+
+	resp, err = c.GetRates(context.Background(), &pb.RatesRequest{Base: "GBP", Destinations: []string{"USD"}})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetRates(), 1)
+	assert.InDelta(t, 1.0581/0.8866, resp.GetRates()[0].GetRate(), 1e-9)
+
+	// one invalid destination fails the whole request
+	for _, rr := range []*pb.RatesRequest{
+		{Base: "EUR", Destinations: []string{"USD", "XXX"}},
+		{Base: "EUR", Destinations: []string{"USD", "EUR"}},
+		{Base: "EUR", Destinations: []string{"usd"}},
+		{Base: "XXX"},
+	} {
+		_, err = c.GetRates(context.Background(), rr)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "%v", rr)
+	}
+}
+
+func TestGetRateMatrix(t *testing.T) {
+	c, _ := newTestCurrency(t)
+
+	// all currencies when none are given, a row per currency without the diagonal
+	resp, err := c.GetRateMatrix(context.Background(), &pb.RateMatrixRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.GetRows(), 4)
+	for _, row := range resp.GetRows() {
+		assert.Len(t, row.GetRates(), 3)
+		for _, r := range row.GetRates() {
+			assert.Equal(t, row.GetBase(), r.GetBase())
+			assert.NotEqual(t, row.GetBase(), r.GetDestination())
+		}
+	}
+
+	resp, err = c.GetRateMatrix(context.Background(), &pb.RateMatrixRequest{Currencies: []string{"USD", "GBP"}})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.GetRows(), 2) {
+		assert.Equal(t, "USD", resp.GetRows()[0].GetBase())
+		assert.Equal(t, "GBP", resp.GetRows()[0].GetRates()[0].GetDestination())
+		assert.Equal(t, "GBP", resp.GetRows()[1].GetBase())
+		assert.Equal(t, "USD", resp.GetRows()[1].GetRates()[0].GetDestination())
+		// the rates of a pair are the inverse of each other
+		assert.InDelta(t, 1, resp.GetRows()[0].GetRates()[0].GetRate()*resp.GetRows()[1].GetRates()[0].GetRate(), 1e-9)
+	}
+
+	_, err = c.GetRateMatrix(context.Background(), &pb.RateMatrixRequest{Currencies: []string{"USD", "XXX"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestConvertRejectsUnknownRoundingModes(t *testing.T) {
+	c, _ := newTestCurrency(t)
+
+	_, err := c.Convert(context.Background(), &pb.ConvertRequest{Base: "EUR", Destination: "USD", Amount: "1.00", Rounding: pb.RoundingMode_ROUND_UP})
+	assert.NoError(t, err)
+
+	// a mode added to the API but not known here must not silently round half even
+	_, err = c.Convert(context.Background(), &pb.ConvertRequest{Base: "EUR", Destination: "USD", Amount: "1.00", Rounding: pb.RoundingMode(42)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
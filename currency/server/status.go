@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/satoshi-u/go-microservices/currency/data"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// statusHeader returns response metadata describing the current reference rates,
+// clients can tell from it when they are being served old rates
+func statusHeader(er *data.ExchangeRates) metadata.MD {
+	s := er.Status()
+	return metadata.Pairs(
+		"x-rates-source", s.Source,
+		"x-rates-date", s.Date.Format(data.DateFormat),
+		"x-rates-age", strconv.Itoa(int(s.Age().Seconds())),
+	)
+}
+
+// RatesStatusUnaryInterceptor adds the rates status headers to unary responses
+func RatesStatusUnaryInterceptor(er *data.ExchangeRates) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		grpc.SetHeader(ctx, statusHeader(er))
+		return handler(ctx, req)
+	}
+}
+
+// RatesStatusStreamInterceptor adds the rates status headers to streams
+func RatesStatusStreamInterceptor(er *data.ExchangeRates) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ss.SetHeader(statusHeader(er))
+		return handler(srv, ss)
+	}
+}
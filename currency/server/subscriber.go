@@ -0,0 +1,271 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/satoshi-u/go-microservices/currency/pb"
+)
+
+// SlowConsumerPolicy decides what happens to an update for a subscriber whose queue is full
+type SlowConsumerPolicy int
+
+const (
+	// DropOldest discards the oldest queued update to make room, the subscriber always gets the latest rates
+	DropOldest SlowConsumerPolicy = iota
+	// DropNewest discards the new update
+	DropNewest
+	// Disconnect ends the subscriber's stream with a ResourceExhausted error
+	Disconnect
+)
+
+// ParseSlowConsumerPolicy parses drop-oldest, drop-newest or disconnect
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch s {
+	case "drop-oldest":
+		return DropOldest, nil
+	case "drop-newest":
+		return DropNewest, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return 0, fmt.Errorf("unknown slow consumer policy %q, expected drop-oldest, drop-newest or disconnect", s)
+}
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return "drop-oldest"
+	}
+}
+
+// DefaultQueueSize is the number of updates buffered for each subscriber unless configured
+const DefaultQueueSize = 16
+
+// subscriber is a SubscribeRates stream with its subscriptions and outbound queue.
+// Updates are queued by handleUpdates without blocking and sent by the stream's
+// own handler goroutine, so a slow client only ever delays itself.
+type subscriber struct {
+	id     string
+	peer   string
+	since  time.Time
+	stream pb.Currency_SubscribeRatesServer
+	policy SlowConsumerPolicy
+	queue  chan *pb.StreamingRateResponse
+	// rate looks up the current rate of a pair
+	rate func(rr *pb.RateRequest) (float64, error)
+
+	// mu guards requests, their timers and dropped
+	mu       sync.Mutex
+	requests []*subscription
+	dropped  int
+
+	// slow is closed when the subscriber is disconnected by the Disconnect policy
+	slow     chan struct{}
+	slowOnce sync.Once
+}
+
+func newSubscriber(stream pb.Currency_SubscribeRatesServer, size int, policy SlowConsumerPolicy) *subscriber {
+	return &subscriber{
+		since:  time.Now(),
+		stream: stream,
+		policy: policy,
+		queue:  make(chan *pb.StreamingRateResponse, size),
+		slow:   make(chan struct{}),
+	}
+}
+
+// subscription is a subscribed pair and the last update sent for it
+type subscription struct {
+	req      *pb.RateRequest
+	last     float64
+	lastSent time.Time
+	// timer sends the update held back by MinInterval once the interval has passed
+	timer *time.Timer
+}
+
+// due returns true when an update from the last sent rate to rate at now satisfies
+// the MinChange and MinInterval of the request
+func (s *subscription) due(rate float64, now time.Time) bool {
+	return s.moved(rate) && s.wait(now) <= 0
+}
+
+// moved returns true when rate differs from the last sent rate by at least MinChange
+func (s *subscription) moved(rate float64) bool {
+	if rate == s.last {
+		return false
+	}
+	if mc := s.req.GetMinChange(); mc > 0 && math.Abs(rate-s.last) < mc*math.Abs(s.last) {
+		return false
+	}
+	return true
+}
+
+// wait returns how long the MinInterval still holds updates back at now
+func (s *subscription) wait(now time.Time) time.Duration {
+	mi := s.req.GetMinInterval()
+	if mi == nil {
+		return 0
+	}
+	return mi.AsDuration() - now.Sub(s.lastSent)
+}
+
+// stop cancels the pending update of the subscription
+func (s *subscription) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// update returns the update for the new rate and records it as sent
+func (s *subscription) update(rate float64, now time.Time) *pb.RateResponse {
+	resp := &pb.RateResponse{
+		Base:         s.req.GetBase(),
+		Destination:  s.req.GetDestination(),
+		Rate:         rate,
+		PreviousRate: s.last,
+	}
+	if s.last != 0 {
+		resp.PercentChange = (rate - s.last) / s.last * 100
+	}
+	s.last = rate
+	s.lastSent = now
+	s.stop()
+	return resp
+}
+
+// subscriptions returns a copy of the subscribed rate requests
+func (s *subscriber) subscriptions() []*pb.RateRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rrs := []*pb.RateRequest{}
+	for _, sb := range s.requests {
+		rrs = append(rrs, sb.req)
+	}
+	return rrs
+}
+
+// updates returns the updates due for the new rates. Pairs which moved enough but are
+// held back by their MinInterval get a timer which sends them when the interval ends,
+// so the client is not left with a stale rate until the next change.
+func (s *subscriber) updates(now time.Time) ([]*pb.StreamingRateResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := []*pb.StreamingRateResponse{}
+	for _, sb := range s.requests {
+		r, err := s.rate(sb.req)
+		if err != nil {
+			return ms, err
+		}
+		if !sb.moved(r) {
+			continue
+		}
+		if wait := sb.wait(now); wait > 0 {
+			if sb.timer == nil {
+				sb := sb
+				sb.timer = time.AfterFunc(wait, func() { s.flush(sb) })
+			}
+			continue
+		}
+		ms = append(ms, &pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_RateResponse{RateResponse: sb.update(r, now)}})
+	}
+	return ms, nil
+}
+
+// flush queues the update held back by the MinInterval of sb, with the rate current now
+func (s *subscriber) flush(sb *subscription) {
+	s.mu.Lock()
+	sb.timer = nil
+	// the pair may have been unsubscribed while the timer was firing
+	i := s.find(sb.req)
+	if i < 0 || s.requests[i] != sb {
+		s.mu.Unlock()
+		return
+	}
+	var m *pb.StreamingRateResponse
+	now := time.Now()
+	r, err := s.rate(sb.req)
+	if err == nil && sb.due(r, now) {
+		m = &pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_RateResponse{RateResponse: sb.update(r, now)}}
+	}
+	s.mu.Unlock()
+
+	// enqueue takes mu when it drops an update
+	if m != nil {
+		s.enqueue(m)
+	}
+}
+
+// stop cancels the pending updates of all subscriptions
+func (s *subscriber) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sb := range s.requests {
+		sb.stop()
+	}
+}
+
+// find returns the index of the subscription for the pair of rr, or -1, mu must be held
+func (s *subscriber) find(rr *pb.RateRequest) int {
+	for i, v := range s.requests {
+		if v.req.GetBase() == rr.GetBase() && v.req.GetDestination() == rr.GetDestination() {
+			return i
+		}
+	}
+	return -1
+}
+
+// enqueue queues m without blocking, applying the policy when the queue is full.
+// It returns false when the update was dropped or the subscriber disconnected.
+func (s *subscriber) enqueue(m *pb.StreamingRateResponse) bool {
+	select {
+	case s.queue <- m:
+		return true
+	default:
+	}
+
+	switch s.policy {
+	case Disconnect:
+		s.slowOnce.Do(func() { close(s.slow) })
+		return false
+	case DropNewest:
+		s.drop()
+		return false
+	default:
+		// make room by dropping the oldest update, the stream may have taken
+		// one in the meantime in which case nothing needs to be dropped
+		select {
+		case <-s.queue:
+			s.drop()
+		default:
+		}
+		select {
+		case s.queue <- m:
+			return true
+		default:
+			s.drop()
+			return false
+		}
+	}
+}
+
+func (s *subscriber) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped++
+}
+
+// droppedUpdates returns the number of updates dropped for the subscriber
+func (s *subscriber) droppedUpdates() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
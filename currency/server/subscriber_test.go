@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/data"
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// fakeStream is a SubscribeRates stream whose client sends the requests and then waits
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	in   chan *pb.RateRequest
+	sent chan *pb.StreamingRateResponse
+}
+
+func newFakeStream(ctx context.Context, sent int, rrs ...*pb.RateRequest) *fakeStream {
+	in := make(chan *pb.RateRequest, len(rrs))
+	for _, rr := range rrs {
+		in <- rr
+	}
+	return &fakeStream{ctx: ctx, in: in, sent: make(chan *pb.StreamingRateResponse, sent)}
+}
+
+func (f *fakeStream) Context() context.Context { return f.ctx }
+
+func (f *fakeStream) Recv() (*pb.RateRequest, error) {
+	select {
+	case rr := <-f.in:
+		return rr, nil
+	case <-f.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+// Send blocks when the client has not read enough, as a slow client would
+func (f *fakeStream) Send(m *pb.StreamingRateResponse) error {
+	select {
+	case f.sent <- m:
+		return nil
+	case <-f.ctx.Done():
+		return f.ctx.Err()
+	}
+}
+
+func newTestCurrency(t *testing.T) (*Currency, *data.ExchangeRates) {
+	fp, err := data.NewFileProvider("../data/testdata/rates.json")
+	assert.NoError(t, err)
+	er, err := data.NewRates(hclog.NewNullLogger(), fp, "EUR", nil, time.Second)
+	assert.NoError(t, err)
+	return NewCurrency(hclog.NewNullLogger(), er), er
+}
+
+func TestEnqueuePolicies(t *testing.T) {
+	msg := func(r float64) *pb.StreamingRateResponse {
+		return &pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_RateResponse{RateResponse: &pb.RateResponse{Rate: r}}}
+	}
+
+	s := newSubscriber(nil, 2, DropOldest)
+	assert.True(t, s.enqueue(msg(1)))
+	assert.True(t, s.enqueue(msg(2)))
+	assert.True(t, s.enqueue(msg(3)))
+	assert.Equal(t, 2.0, (<-s.queue).GetRateResponse().GetRate())
+	assert.Equal(t, 1, s.droppedUpdates())
+
+	s = newSubscriber(nil, 1, DropNewest)
+	assert.True(t, s.enqueue(msg(1)))
+	assert.False(t, s.enqueue(msg(2)))
+	assert.Equal(t, 1.0, (<-s.queue).GetRateResponse().GetRate())
+
+	s = newSubscriber(nil, 1, Disconnect)
+	assert.True(t, s.enqueue(msg(1)))
+	assert.False(t, s.enqueue(msg(2)))
+	<-s.slow
+}
+
+func TestSlowSubscriberDoesNotBlockOthers(t *testing.T) {
+	c, er := newTestCurrency(t)
+	c.WithSubscriberQueue(1, Disconnect)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rr := &pb.RateRequest{Base: "GBP", Destination: "USD"}
+
+	// the slow client never reads what is sent to it, its Send only returns
+	// once the stream is torn down
+	slowCtx, closeSlow := context.WithCancel(ctx)
+	slow := newFakeStream(slowCtx, 0, rr)
+	slowErr := make(chan error, 1)
+	go func() { slowErr <- c.SubscribeRates(slow) }()
+
+	fast := newFakeStream(ctx, 100, rr)
+	go c.SubscribeRates(fast)
+
+	assert.Eventually(t, func() bool {
+		for _, sub := range c.subscribers() {
+			if len(sub.subscriptions()) != 1 {
+				return false
+			}
+		}
+		return len(c.subscribers()) == 2
+	}, time.Second, time.Millisecond)
+
+	var slowSub *subscriber
+	for _, sub := range c.subscribers() {
+		if sub.stream == slow {
+			slowSub = sub
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		er.Update(func(rates map[string]float64) { rates["USD"] += 0.01 })
+		select {
+		case <-fast.sent:
+		case <-time.After(time.Second):
+			t.Fatal("fast subscriber did not get the update")
+		}
+		if i == 0 {
+			// the slow writer takes the first update and blocks in Send, otherwise the
+			// handler may rightly return before its writer ever sends
+			assert.Eventually(t, func() bool { return len(slowSub.queue) == 0 }, time.Second, time.Millisecond)
+		}
+	}
+
+	// the slow subscriber is dropped straight away, but its handler only returns
+	// once its writer is no longer blocked in Send
+	assert.Eventually(t, func() bool { return len(c.subscribers()) == 1 }, 5*time.Second, time.Millisecond)
+	select {
+	case <-slowErr:
+		t.Fatal("handler returned while its writer was still sending")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	closeSlow()
+	select {
+	case err := <-slowErr:
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow subscriber was not disconnected")
+	}
+}
+
+func TestSubscriptionManagement(t *testing.T) {
+	c, _ := newTestCurrency(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gbpUSD := &pb.RateRequest{Base: "GBP", Destination: "USD"}
+	s := newFakeStream(ctx, 10, gbpUSD, &pb.RateRequest{Base: "GBP", Destination: "INR"})
+	done := make(chan error, 1)
+	go func() { done <- c.SubscribeRates(s) }()
+
+	pairs := func() []*pb.RateRequest {
+		resp, err := c.ListSubscriptions(context.Background(), &pb.ListSubscriptionsRequest{})
+		assert.NoError(t, err)
+		if len(resp.Subscriptions) == 0 {
+			return nil
+		}
+		return resp.Subscriptions[0].Pairs
+	}
+	assert.Eventually(t, func() bool { return len(pairs()) == 2 }, time.Second, time.Millisecond)
+
+	s.in <- &pb.RateRequest{Base: "GBP", Destination: "USD", Action: pb.SubscriptionAction_UNSUBSCRIBE}
+	assert.Eventually(t, func() bool { return len(pairs()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, "INR", pairs()[0].GetDestination())
+
+	// unsubscribing again is an error on the stream
+	s.in <- &pb.RateRequest{Base: "GBP", Destination: "USD", Action: pb.SubscriptionAction_UNSUBSCRIBE}
+	m := <-s.sent
+	assert.Equal(t, int32(codes.NotFound), m.GetError().GetCode())
+
+	// the stream and its subscriptions are removed when the client goes away
+	cancel()
+	<-done
+	assert.Empty(t, c.subscribers())
+}
+
+func TestSubscriptionThresholds(t *testing.T) {
+	now := time.Now()
+	sb := &subscription{
+		req:      &pb.RateRequest{MinChange: 0.01, MinInterval: durationpb.New(time.Minute)},
+		last:     100,
+		lastSent: now,
+	}
+
+	// too small a change, then too soon
+	assert.False(t, sb.due(100.5, now.Add(2*time.Minute)))
+	assert.False(t, sb.due(102, now.Add(30*time.Second)))
+
+	assert.True(t, sb.due(102, now.Add(2*time.Minute)))
+	resp := sb.update(102, now.Add(2*time.Minute))
+	assert.Equal(t, 100.0, resp.GetPreviousRate())
+	assert.InDelta(t, 2.0, resp.GetPercentChange(), 1e-9)
+
+	// changes are measured from the last update sent
+	assert.False(t, sb.due(101.5, now.Add(4*time.Minute)))
+	assert.True(t, sb.due(100, now.Add(4*time.Minute)))
+}
+
+func TestThrottledUpdatesAreSentWhenTheIntervalEnds(t *testing.T) {
+	c, er := newTestCurrency(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newFakeStream(ctx, 10, &pb.RateRequest{Base: "EUR", Destination: "USD", MinInterval: durationpb.New(200 * time.Millisecond)})
+	go c.SubscribeRates(s)
+	assert.Eventually(t, func() bool {
+		subs := c.subscribers()
+		return len(subs) == 1 && len(subs[0].subscriptions()) == 1
+	}, time.Second, time.Millisecond)
+
+	// the change comes too soon after subscribing and no other change follows
+	er.Update(func(rates map[string]float64) { rates["USD"] += 0.1 })
+	select {
+	case m := <-s.sent:
+		t.Fatalf("update sent before the interval ended: %v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	select {
+	case m := <-s.sent:
+		rate, err := er.GetRate("EUR", "USD")
+		assert.NoError(t, err)
+		assert.Equal(t, rate, m.GetRateResponse().GetRate())
+	case <-time.After(2 * time.Second):
+		t.Fatal("held back update was never sent")
+	}
+}
@@ -15,6 +15,28 @@ class CoffeeList extends React.Component {
         });
     }
 
+    // listen for live prices, the server sends the full list on connect and on every change
+    subscribe() {
+        const self = this;
+        this.stream = new EventSource(window.global.api_location+'/products/stream');
+        this.stream.addEventListener('products', function(event) {
+            self.setState({products: JSON.parse(event.data)});
+        });
+        this.stream.onerror = function(error) {
+            console.log(error);
+        };
+    }
+
+    componentDidMount() {
+        this.subscribe();
+    }
+
+    componentWillUnmount() {
+        if (this.stream) {
+            this.stream.close();
+        }
+    }
+
     getProducts() {
         let table = []
 
@@ -6,9 +6,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-hclog"
+	currencydata "github.com/satoshi-u/go-microservices/currency/data"
 	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/satoshi-u/go-microservices/product-api/events"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -112,72 +119,177 @@ func (p *Products) JsonMarshalProducts() ([]byte, error) {
 type ProductsDB struct {
 	cc          pb.CurrencyClient // not to pass by ref, since it's an interface
 	log         hclog.Logger
-	ratesCached map[string]float64               // cached rates
+	base        string                           // currency the product prices are stored in
+	mu          sync.Mutex                       // guards ratesCached, subscribed & subRClient
+	ratesCached map[string]float64               // rates of the subscribed currencies, kept up to date by handleUpdates
+	subscribed  map[string]bool                  // destination currencies subscribed for rate updates, on every stream
 	subRClient  pb.Currency_SubscribeRatesClient // client instance for pdb
+	events      *events.Publisher                // domain events for product changes, may be nil
+	watchers    watchers                         // in process watchers of changes, see Watch
 }
 
-// New ProductsDB
-func NewProductsDB(cc pb.CurrencyClient, l hclog.Logger) *ProductsDB {
-	pdb := &ProductsDB{cc, l, map[string]float64{}, nil}
+// New ProductsDB with prices stored in the base currency, ep receives an event
+// for every create/update/delete and may be nil
+func NewProductsDB(cc pb.CurrencyClient, l hclog.Logger, base string, ep *events.Publisher) *ProductsDB {
+	pdb := &ProductsDB{
+		cc:          cc,
+		log:         l,
+		base:        strings.ToUpper(base),
+		ratesCached: map[string]float64{},
+		subscribed:  map[string]bool{},
+		events:      ep,
+	}
 	go pdb.handleUpdates() // listens in background for updated rates for current client
 	return pdb
 }
 
-// handleUpdates- subscribed client receives updated Rate Responses
+// Base returns the currency the product prices are stored in
+func (pdb *ProductsDB) Base() string {
+	return pdb.base
+}
+
+// Backoff between attempts to open the rate updates stream, doubled after
+// each failure up to the max and reset once a stream receives an update
+const (
+	reconnectMinBackoff = 500 * time.Millisecond
+	reconnectMaxBackoff = 30 * time.Second
+)
+
+// handleUpdates- subscribed client receives updated Rate Responses, the stream is
+// opened again with backoff whenever it fails so prices keep updating
 func (pdb *ProductsDB) handleUpdates() {
+	backoff := reconnectMinBackoff
+	for {
+		received, err := pdb.receiveUpdates()
+		if received {
+			backoff = reconnectMinBackoff
+		}
+		pdb.log.Error("Rate updates stream failed, reconnecting", "error", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// receiveUpdates opens the rate updates stream, subscribes again to every currency
+// subscribed so far and receives updates until the stream fails. received is true
+// when at least one message arrived.
+func (pdb *ProductsDB) receiveUpdates() (received bool, err error) {
 	// instantiate subRClient
-	subRClient, err := pdb.cc.SubscribeRates(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subRClient, err := pdb.cc.SubscribeRates(ctx)
 	if err != nil {
-		pdb.log.Error("Unable to subscribe for rates", "error", err)
-		return
+		return false, fmt.Errorf("unable to subscribe for rates: %w", err)
 	}
-
-	// save client instance in pdb
+	defer func() {
+		// the cached rates are not updated anymore and the subscriptions are made
+		// again on the next stream, subscribe must not send on this one
+		pdb.mu.Lock()
+		pdb.subRClient = nil
+		pdb.ratesCached = map[string]float64{}
+		pdb.mu.Unlock()
+	}()
+
+	// save client instance in pdb and resubscribe on the new stream
+	pdb.mu.Lock()
 	pdb.subRClient = subRClient
+	for dest := range pdb.subscribed {
+		err := subRClient.Send(&pb.RateRequest{Base: pdb.base, Destination: dest}) // @gRPC stream{client -> server}
+		if err != nil {
+			pdb.mu.Unlock()
+			return false, fmt.Errorf("unable to subscribe for rate updates of %s: %w", dest, err)
+		}
+	}
+	pdb.mu.Unlock()
+
+	// fetch the rates of the subscribed currencies in one round trip
+	pdb.warmCache()
 
 	// listening in loop for rate updates,
 	// if duplicate subscription request sent - handle @ gRPC Error messages in gRPC bi-directional stream - { client side }
 	for {
 		rr, err := subRClient.Recv() // @gRPC stream{client <- server}
+		if err != nil {
+			return received, err
+		}
+		received = true
 
 		// duplicate subscription error check
 		if grpcError := rr.GetError(); grpcError != nil {
 			// grpcError.Code
-			pdb.log.Error("error subscribing for rates", "error", err)
+			pdb.log.Error("error subscribing for rates", "error", grpcError.GetMessage())
 			continue
 		}
 
 		// valid rate-response, not any random error
 		if resp := rr.GetRateResponse(); resp != nil {
-			pdb.log.Info("Received updated rate from server", "dest", resp.GetDestination().String())
+			pdb.log.Info("Received updated rate from server", "dest", resp.GetDestination())
 
-			if err != nil {
-				pdb.log.Error("Error receiving message", "error", err)
-				return
-			}
+			pdb.mu.Lock()
+			pdb.ratesCached[resp.Destination] = resp.Rate
+			pdb.mu.Unlock()
 
-			pdb.ratesCached[resp.Destination.String()] = resp.Rate
+			pdb.notify(Change{Type: ChangeRateUpdated, Currency: resp.Destination, Rate: resp.Rate})
 		}
 
 	}
 }
 
+// warmCache refreshes the cached rates of every subscribed currency in a single call,
+// as the rates may have changed while there was no stream to receive the updates.
+// Currencies are only subscribed once prices are requested in them, see subscribe.
+func (pdb *ProductsDB) warmCache() {
+	pdb.mu.Lock()
+	dests := make([]string, 0, len(pdb.subscribed))
+	for dest := range pdb.subscribed {
+		dests = append(dests, dest)
+	}
+	pdb.mu.Unlock()
+	if len(dests) == 0 {
+		return
+	}
+
+	resp, err := pdb.cc.GetRates(context.Background(), &pb.RatesRequest{Base: pdb.base, Destinations: dests})
+	if err != nil {
+		pdb.log.Error("Unable to warm rates cache", "error", err)
+		return
+	}
+
+	pdb.mu.Lock()
+	defer pdb.mu.Unlock()
+	if pdb.subRClient == nil {
+		// the stream failed in the meantime, the rates would not be kept up to date
+		return
+	}
+	for _, r := range resp.GetRates() {
+		pdb.ratesCached[r.GetDestination()] = r.GetRate()
+	}
+	pdb.log.Info("Warmed rates cache", "currencies", len(resp.GetRates()))
+}
+
 // GetProducts returns a list of products
 func (pdb *ProductsDB) GetProducts(currency string) (Products, error) {
 	if currency == "" {
 		return productList, nil
 	}
 
-	rate, err := pdb.fetchRate(currency)
+	// a single rate for every product, from the cache once the currency is subscribed
+	rate, err := pdb.rate(currency)
 	if err != nil {
-		pdb.log.Error("unable to get rate", "currency", currency, "error", err)
+		pdb.log.Error("unable to convert price", "currency", currency, "error", err)
 		return nil, err
 	}
 
 	pr := Products{}
 	for _, p := range productList {
 		np := *p // np is a copy, not ref
-		np.Price = np.Price * rate
+		np.Price, err = pdb.convertPrice(np.Price, rate, currency)
+		if err != nil {
+			return nil, err
+		}
 		pr = append(pr, &np)
 	}
 	return pr, nil
@@ -187,6 +299,7 @@ func (pdb *ProductsDB) GetProducts(currency string) (Products, error) {
 func (pdb *ProductsDB) AddProduct(p *Product) *Product {
 	p.ID = getNextId()
 	productList = append(productList, p)
+	pdb.publish(events.ProductCreated, p)
 	return p
 }
 
@@ -198,6 +311,7 @@ func (pdb *ProductsDB) UpdateProduct(p *Product) (*Product, error) {
 	}
 	// update product in db
 	productList[i] = p
+	pdb.publish(events.ProductUpdated, p)
 	return p, nil
 }
 
@@ -213,10 +327,27 @@ func (pdb *ProductsDB) DeleteProduct(id int) (*Product, error) {
 	copy(productList[i:], productList[i+1:])       // Shift productList[i+1:] left one index.
 	productList[len(productList)-1] = nil          // Erase last element (write zero value).
 	productList = productList[:len(productList)-1] // Truncate slice.
+	pdb.publish(events.ProductDeleted, pdel)
 	// return deleted product
 	return pdel, nil
 }
 
+// publish raises a domain event for a change to the product and notifies watchers,
+// the change itself has already been made so a failure is logged rather than returned
+func (pdb *ProductsDB) publish(eventType string, p *Product) {
+	pdb.notify(Change{Type: eventType, Product: p})
+	if pdb.events == nil {
+		return
+	}
+	e, err := events.NewEvent(eventType, p.ID, p)
+	if err == nil {
+		err = pdb.events.Publish(e)
+	}
+	if err != nil {
+		pdb.log.Error("Unable to publish product event", "type", eventType, "id", p.ID, "error", err)
+	}
+}
+
 // getNextId calculates ID for a new product to be added
 func getNextId() int {
 	lp := productList[len(productList)-1]
@@ -237,16 +368,26 @@ func (pdb *ProductsDB) GetProductByID(id int, currency string) (*Product, error)
 	if currency == "" {
 		return productList[i], nil
 	}
+	return pdb.ConvertProduct(productList[i], currency)
+}
 
-	rate, err := pdb.fetchRate(currency)
+// ConvertProduct returns a copy of the product priced in the currency, it also works
+// for products which are no longer in the database such as the deleted ones
+func (pdb *ProductsDB) ConvertProduct(p *Product, currency string) (*Product, error) {
+	np := *p // copy of product, note: product is not a deep object, flat struct
+	if currency == "" {
+		return &np, nil
+	}
+
+	rate, err := pdb.rate(currency)
+	if err != nil {
+		pdb.log.Error("unable to convert price", "currency", currency, "error", err)
+		return nil, err
+	}
+	np.Price, err = pdb.convertPrice(np.Price, rate, currency)
 	if err != nil {
-		pdb.log.Error("unable to get rate", "currency", currency, "error", err)
 		return nil, err
 	}
-
-	np := *productList[i] // copy of product, note: product is not a deep object, flat struct
-	np.Price = np.Price * rate
-
 	return &np, nil
 }
 
@@ -261,40 +402,95 @@ func findIndexByProductID(id int) int {
 	return -1
 }
 
-// helper-  get exchange rate for destination currency, base currency is EUR
-func (pdb *ProductsDB) fetchRate(destination string) (float64, error) {
-	// if uncommented, duplicate subscription rate request will never go thru, no gRPC bi-directional error will surface
-	/*
-		// if cached, return
-		if r, ok := pdb.ratesCached[destination]; ok {
-			return r, nil
+// ErrInvalidCurrency is returned when prices are requested in a currency the currency service does not know
+var ErrInvalidCurrency = fmt.Errorf("Invalid currency")
+
+// CheckCurrency returns ErrInvalidCurrency when the currency service has no rate for code,
+// it is used at startup so an unknown base currency is reported before the first conversion fails
+func CheckCurrency(ctx context.Context, cc pb.CurrencyClient, code string) error {
+	// wait for the currency server to come up until ctx is done
+	resp, err := cc.ListCurrencies(ctx, &pb.ListCurrenciesRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return fmt.Errorf("unable to list currencies from currency server: %w", err)
+	}
+	for _, ci := range resp.GetCurrencies() {
+		if ci.GetCode() == code {
+			return nil
 		}
-	*/
+	}
+	return fmt.Errorf("%w %q: the currency server has no rate for it", ErrInvalidCurrency, code)
+}
 
-	// or get initial rate first time
-	rr := &pb.RateRequest{
-		Base:        pb.Currencies(pb.Currencies_value["EUR"]), // *** EUR as base always
-		Destination: pb.Currencies(pb.Currencies_value[destination]),
+// rate returns the rate from the base currency to the destination. Subscribed currencies
+// are kept up to date by handleUpdates, the others are fetched from the currency service,
+// which also validates the currency, and subscribed for the next time.
+func (pdb *ProductsDB) rate(destination string) (float64, error) {
+	destination = strings.ToUpper(destination)
+	if destination == pdb.base {
+		return 1, nil
 	}
+
+	pdb.mu.Lock()
+	rate, ok := pdb.ratesCached[destination]
+	pdb.mu.Unlock()
+	if ok {
+		return rate, nil
+	}
+
+	rr := &pb.RateRequest{Base: pdb.base, Destination: destination}
 	resp, err := pdb.cc.GetRate(context.Background(), rr)
 	// gRPC Error messages in Unary RPCs - at client side
 	if err != nil {
-		if s, ok := status.FromError(err); ok {
-			// gRPC err message - yes
-			metaData := s.Details()[0].(*pb.RateRequest)
-			if s.Code() == codes.InvalidArgument {
-				return -1, fmt.Errorf("unable to get rate from currency server { DESTINATION AND BASE CURRENCIES CANNOT BE THE SAME }, base: %s, dest: %s", metaData.Base.String(), metaData.Destination.String())
-			}
-			return -1, fmt.Errorf("unable to get rate from currency server, base: %s, dest: %s", metaData.Base.String(), metaData.Destination.String())
+		if s, ok := status.FromError(err); ok && s.Code() == codes.InvalidArgument {
+			// unknown currency
+			return -1, fmt.Errorf("%w %q: %s", ErrInvalidCurrency, destination, s.Message())
 		}
+		return -1, fmt.Errorf("unable to get rate from currency server, base: %s, dest: %s: %w", rr.Base, rr.Destination, err)
+	}
+
+	pdb.subscribe(rr, resp.GetRate())
+	return resp.GetRate(), nil
+}
+
+// helper- converts a price in the base currency to the destination currency with the rate,
+// rounded half to even to the minor units of the destination currency like the Convert RPC
+func (pdb *ProductsDB) convertPrice(price, rate float64, destination string) (float64, error) {
+	destination = strings.ToUpper(destination)
+	if destination == pdb.base {
+		// prices are stored in the base currency, there is nothing to convert
+		return price, nil
+	}
+
+	amount, err := currencydata.ParseDecimal(strconv.FormatFloat(price, 'f', -1, 64))
+	if err != nil {
 		return -1, err
 	}
-	pdb.ratesCached[destination] = resp.Rate // update cache for first time
+	converted, _ := currencydata.Convert(amount, rate, destination, currencydata.RoundHalfEven).Float64()
+	return converted, nil
+}
 
-	// subscribe for updated rates for destination currency
-	pdb.subRClient.Send(rr) // @gRPC stream{client -> server}
+// subscribe caches the rate and subscribes for its updates, once per currency
+// as later updates arrive in handleUpdates. Without a stream the currency is
+// subscribed when handleUpdates opens the next one.
+func (pdb *ProductsDB) subscribe(rr *pb.RateRequest, rate float64) {
+	destination := rr.GetDestination()
 
-	return resp.Rate, err
+	pdb.mu.Lock()
+	defer pdb.mu.Unlock()
+	if pdb.subscribed[destination] {
+		return
+	}
+	pdb.subscribed[destination] = true
+	if pdb.subRClient == nil {
+		return
+	}
+	// only cached while the stream keeps it up to date
+	pdb.ratesCached[destination] = rate
+	err := pdb.subRClient.Send(rr) // @gRPC stream{client -> server}
+	if err != nil {
+		// the stream is broken, handleUpdates subscribes again on the next one
+		pdb.log.Error("Unable to subscribe for rate updates", "currency", destination, "error", err)
+	}
 }
 
 // productList is a hard coded list of products for this
@@ -2,10 +2,20 @@ package data
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/satoshi-u/go-microservices/product-api/events"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // TestProductMissingNameReturnsErr
@@ -77,3 +87,240 @@ func TestProductsToJSON(t *testing.T) {
 // 		t.Fail()
 // 	}
 // }
+
+func TestConvertPriceInBaseCurrency(t *testing.T) {
+	// prices in the base currency are returned as stored, without calling the currency service
+	pdb := &ProductsDB{base: "USD"}
+	rate, err := pdb.rate("usd")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, rate)
+	price, err := pdb.convertPrice(2.45, rate, "usd")
+	assert.NoError(t, err)
+	assert.Equal(t, 2.45, price)
+}
+
+func TestConvertPriceRoundsHalfEven(t *testing.T) {
+	pdb := &ProductsDB{base: "EUR"}
+	for _, tc := range []struct {
+		price, rate float64
+		dest        string
+		want        float64
+	}{
+		{2.45, 0.5, "USD", 1.22},
+		{2.55, 0.5, "USD", 1.28},
+		{1.99, 100, "INR", 199},
+		// yen have no minor units
+		{2.45, 143.1, "JPY", 351},
+	} {
+		price, err := pdb.convertPrice(tc.price, tc.rate, tc.dest)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.want, price, "%v", tc)
+	}
+}
+
+func TestGetProductsUsesOneRate(t *testing.T) {
+	cc := &stubCurrency{streams: make(chan *stubStream, 1)}
+	pdb := NewProductsDB(cc, hclog.NewNullLogger(), "EUR", nil)
+
+	// without a stream every request gets the rate once, whatever the number of products
+	prods, err := pdb.GetProducts("usd")
+	assert.NoError(t, err)
+	assert.Len(t, prods, len(productList))
+	assert.Equal(t, 2.45*2, prods[0].Price)
+	assert.Equal(t, int32(1), cc.rateCalls())
+
+	_, err = pdb.GetProducts("XXX")
+	assert.ErrorIs(t, err, ErrInvalidCurrency)
+
+	// once subscribed the rate comes from the cache
+	st := newStubStream()
+	cc.streams <- st
+	assert.Eventually(t, func() bool {
+		pdb.mu.Lock()
+		defer pdb.mu.Unlock()
+		return pdb.subRClient != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	_, err = pdb.GetProducts("USD")
+	assert.NoError(t, err)
+	calls := cc.rateCalls()
+
+	st.recv <- rateUpdate("USD", 3)
+	assert.Eventually(t, func() bool {
+		p, err := pdb.GetProductByID(1, "USD")
+		return err == nil && p.Price == 7.35
+	}, 2*time.Second, 10*time.Millisecond)
+	_, err = pdb.GetProducts("USD")
+	assert.NoError(t, err)
+	assert.Equal(t, calls, cc.rateCalls())
+}
+
+// stubCurrency is a pb.CurrencyClient for tests, the methods which are not set panic
+type stubCurrency struct {
+	pb.CurrencyClient
+	// streams are returned by SubscribeRates in order, without streams it fails
+	streams chan *stubStream
+	// calls counts the GetRate calls
+	calls int32
+}
+
+// GetRate returns rate 2 for USD, other currencies are invalid
+func (s *stubCurrency) GetRate(ctx context.Context, in *pb.RateRequest, opts ...grpc.CallOption) (*pb.RateResponse, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if in.GetDestination() != "USD" {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown currency %s", in.GetDestination())
+	}
+	return &pb.RateResponse{Base: in.GetBase(), Destination: in.GetDestination(), Rate: 2}, nil
+}
+
+// ListCurrencies knows the base currency of the tests and USD
+func (s *stubCurrency) ListCurrencies(ctx context.Context, in *pb.ListCurrenciesRequest, opts ...grpc.CallOption) (*pb.ListCurrenciesResponse, error) {
+	return &pb.ListCurrenciesResponse{Currencies: []*pb.CurrencyInfo{{Code: "EUR"}, {Code: "USD"}}}, nil
+}
+
+func (s *stubCurrency) rateCalls() int32 {
+	return atomic.LoadInt32(&s.calls)
+}
+
+// SubscribeRates returns the next stream, or fails so ProductsDB runs without rate updates
+func (s *stubCurrency) SubscribeRates(ctx context.Context, opts ...grpc.CallOption) (pb.Currency_SubscribeRatesClient, error) {
+	if s.streams == nil {
+		return nil, status.Error(codes.Unavailable, "no rate updates in tests")
+	}
+	select {
+	case st := <-s.streams:
+		return st, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GetRates returns rate 1.25 for every destination
+func (s *stubCurrency) GetRates(ctx context.Context, in *pb.RatesRequest, opts ...grpc.CallOption) (*pb.RatesResponse, error) {
+	resp := &pb.RatesResponse{Base: in.GetBase()}
+	for _, d := range in.GetDestinations() {
+		resp.Rates = append(resp.Rates, &pb.RateResponse{Base: in.GetBase(), Destination: d, Rate: 1.25})
+	}
+	return resp, nil
+}
+
+// stubStream is a rate updates stream, closing recv fails the stream
+type stubStream struct {
+	grpc.ClientStream
+	sent chan *pb.RateRequest
+	recv chan *pb.StreamingRateResponse
+}
+
+func newStubStream() *stubStream {
+	return &stubStream{sent: make(chan *pb.RateRequest, 32), recv: make(chan *pb.StreamingRateResponse)}
+}
+
+func (s *stubStream) Send(rr *pb.RateRequest) error {
+	s.sent <- rr
+	return nil
+}
+
+func (s *stubStream) Recv() (*pb.StreamingRateResponse, error) {
+	rr, ok := <-s.recv
+	if !ok {
+		return nil, io.EOF
+	}
+	return rr, nil
+}
+
+// rateUpdate returns a streamed rate for the destination
+func rateUpdate(dest string, rate float64) *pb.StreamingRateResponse {
+	return &pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_RateResponse{
+		RateResponse: &pb.RateResponse{Base: "EUR", Destination: dest, Rate: rate},
+	}}
+}
+
+// keepProducts restores the hard coded products after a test changes them
+func keepProducts(t *testing.T) {
+	saved := append(Products{}, productList...)
+	t.Cleanup(func() { productList = saved })
+}
+
+func TestProductChangesPublishEvents(t *testing.T) {
+	keepProducts(t)
+	cs := events.NewChannelSink("test", 3)
+	ep := events.NewPublisher(hclog.NewNullLogger(), events.NewMemoryOutbox(), time.Second, cs)
+	defer ep.Close()
+	pdb := NewProductsDB(&stubCurrency{}, hclog.NewNullLogger(), "EUR", ep)
+
+	p := pdb.AddProduct(&Product{Name: "Mocha", Price: 3.10, SKU: "prod-bev-003"})
+	_, err := pdb.UpdateProduct(&Product{ID: p.ID, Name: "Mocha", Price: 3.20, SKU: "prod-bev-003"})
+	assert.NoError(t, err)
+	_, err = pdb.DeleteProduct(p.ID)
+	assert.NoError(t, err)
+
+	// missing products raise no events
+	_, err = pdb.DeleteProduct(p.ID)
+	assert.ErrorIs(t, err, ErrProductNotFound)
+
+	for _, typ := range []string{events.ProductCreated, events.ProductUpdated, events.ProductDeleted} {
+		select {
+		case e := <-cs.Events():
+			assert.Equal(t, typ, e.Type)
+			assert.Equal(t, p.ID, e.ProductID)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no %s event", typ)
+		}
+	}
+	select {
+	case e := <-cs.Events():
+		t.Fatalf("unexpected %s event", e.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandleUpdatesReconnects(t *testing.T) {
+	cc := &stubCurrency{streams: make(chan *stubStream, 2)}
+	first, second := newStubStream(), newStubStream()
+	cc.streams <- first
+	pdb := NewProductsDB(cc, hclog.NewNullLogger(), "EUR", nil)
+	changes, stop := pdb.Watch()
+	defer stop()
+
+	assert.Eventually(t, func() bool {
+		pdb.mu.Lock()
+		defer pdb.mu.Unlock()
+		return pdb.subRClient != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	// nothing is subscribed until prices are requested in a currency
+	assert.Len(t, first.sent, 0)
+	pdb.subscribe(&pb.RateRequest{Base: "EUR", Destination: "USD"}, 1.1)
+	assert.Equal(t, "USD", (<-first.sent).GetDestination())
+	assert.Len(t, first.sent, 0)
+
+	first.recv <- rateUpdate("USD", 1.2)
+	c := <-changes
+	assert.Equal(t, ChangeRateUpdated, c.Type)
+	assert.Equal(t, 1.2, c.Rate)
+
+	// the stream fails, the currency is subscribed again on the next one
+	close(first.recv)
+	cc.streams <- second
+	select {
+	case rr := <-second.sent:
+		assert.Equal(t, "USD", rr.GetDestination())
+	case <-time.After(5 * time.Second):
+		t.Fatal("currency was not subscribed again")
+	}
+
+	// the rates missed while disconnected are fetched again
+	assert.Eventually(t, func() bool {
+		pdb.mu.Lock()
+		defer pdb.mu.Unlock()
+		return pdb.ratesCached["USD"] == 1.25
+	}, 2*time.Second, 10*time.Millisecond)
+
+	second.recv <- rateUpdate("USD", 1.3)
+	c = <-changes
+	assert.Equal(t, 1.3, c.Rate)
+}
+
+func TestCheckCurrency(t *testing.T) {
+	cc := &stubCurrency{}
+	assert.NoError(t, CheckCurrency(context.Background(), cc, "EUR"))
+	assert.ErrorIs(t, CheckCurrency(context.Background(), cc, "XXX"), ErrInvalidCurrency)
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"sync"
+
+	"github.com/satoshi-u/go-microservices/product-api/events"
+)
+
+// Types of Change sent to watchers, product changes use the same names as the domain events
+const (
+	ChangeProductCreated = events.ProductCreated
+	ChangeProductUpdated = events.ProductUpdated
+	ChangeProductDeleted = events.ProductDeleted
+	ChangeRateUpdated    = "rate.updated"
+)
+
+// Change describes something which affects the products or their prices
+type Change struct {
+	// Type is one of the Change* constants
+	Type string
+	// Product is set for product changes
+	Product *Product
+	// Currency and Rate are set for rate changes, the rate is against the base currency
+	Currency string
+	Rate     float64
+}
+
+// watchers is the set of channels receiving changes from a ProductsDB
+type watchers struct {
+	mu sync.Mutex
+	ws map[chan Change]struct{}
+}
+
+// Watch returns a channel receiving every change to the products or to a
+// subscribed rate, the returned func must be called to stop watching.
+// A watcher which does not keep up misses changes rather than blocking others.
+func (pdb *ProductsDB) Watch() (<-chan Change, func()) {
+	ch := make(chan Change, 16)
+
+	pdb.watchers.mu.Lock()
+	if pdb.watchers.ws == nil {
+		pdb.watchers.ws = map[chan Change]struct{}{}
+	}
+	pdb.watchers.ws[ch] = struct{}{}
+	pdb.watchers.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			pdb.watchers.mu.Lock()
+			delete(pdb.watchers.ws, ch)
+			pdb.watchers.mu.Unlock()
+		})
+	}
+}
+
+// notify sends the change to every watcher without blocking
+func (pdb *ProductsDB) notify(c Change) {
+	pdb.watchers.mu.Lock()
+	defer pdb.watchers.mu.Unlock()
+
+	for ch := range pdb.watchers.ws {
+		select {
+		case ch <- c:
+		default:
+			pdb.log.Warn("Dropping change for slow watcher", "type", c.Type)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchReceivesChanges(t *testing.T) {
+	keepProducts(t)
+	pdb := &ProductsDB{log: hclog.NewNullLogger()}
+	changes, stop := pdb.Watch()
+
+	p := pdb.AddProduct(&Product{Name: "Mocha", Price: 3.10, SKU: "prod-bev-003"})
+	c := <-changes
+	assert.Equal(t, ChangeProductCreated, c.Type)
+	assert.Equal(t, p, c.Product)
+
+	pdb.notify(Change{Type: ChangeRateUpdated, Currency: "USD", Rate: 1.1})
+	c = <-changes
+	assert.Equal(t, ChangeRateUpdated, c.Type)
+	assert.Equal(t, "USD", c.Currency)
+
+	// stopped watchers get nothing, stopping twice is fine
+	stop()
+	stop()
+	pdb.notify(Change{Type: ChangeRateUpdated, Currency: "USD", Rate: 1.2})
+	assert.Len(t, changes, 0)
+}
+
+func TestNotifyDropsChangesForSlowWatchers(t *testing.T) {
+	pdb := &ProductsDB{log: hclog.NewNullLogger()}
+	slow, stopSlow := pdb.Watch()
+	defer stopSlow()
+	fast, stopFast := pdb.Watch()
+	defer stopFast()
+
+	// the slow watcher never reads, notify must not block on it
+	for i := 0; i < cap(slow)+5; i++ {
+		pdb.notify(Change{Type: ChangeRateUpdated, Currency: "USD", Rate: float64(i)})
+		assert.Equal(t, float64(i), (<-fast).Rate)
+	}
+	assert.Len(t, slow, cap(slow))
+}
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Types of domain events raised for changes to the products catalogue
+const (
+	ProductCreated = "product.created"
+	ProductUpdated = "product.updated"
+	ProductDeleted = "product.deleted"
+)
+
+// Event is a domain event describing a single change to a product
+type Event struct {
+	// ID uniquely identifies the event, sinks can use it to de-duplicate redeliveries
+	ID string `json:"id"`
+	// Type is one of ProductCreated, ProductUpdated or ProductDeleted
+	Type string `json:"type"`
+	// ProductID is the id of the product which changed
+	ProductID int `json:"product_id"`
+	// Time is when the change happened
+	Time time.Time `json:"time"`
+	// Data is the JSON encoded state of the product after the change
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// NewEvent creates a new event of the given type, data is encoded as JSON
+func NewEvent(eventType string, productID int, data interface{}) (*Event, error) {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:        newID(),
+		Type:      eventType,
+		ProductID: productID,
+		Time:      time.Now().UTC(),
+		Data:      d,
+	}, nil
+}
+
+// newID returns a random 128 bit hex encoded identifier
+func newID() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails when the OS entropy source is unavailable
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,220 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Record is an event stored in the Outbox along with its delivery progress
+type Record struct {
+	Event *Event `json:"event"`
+	// Delivered holds the names of the sinks which have accepted the event
+	Delivered map[string]bool `json:"delivered"`
+	// Attempts is the number of dispatch rounds the event has been through
+	Attempts int `json:"attempts"`
+}
+
+// Outbox durably stores events until they have been delivered to every sink
+// Implementations may be of the type -> local disk, in memory, database table, etc
+type Outbox interface {
+	// Append stores a new event
+	Append(e *Event) error
+	// Pending returns the records which have not yet been removed, oldest first
+	Pending() ([]*Record, error)
+	// Update persists the delivery progress of a record
+	Update(r *Record) error
+	// Remove deletes a record once it has been delivered everywhere
+	Remove(id string) error
+}
+
+// FileOutbox is an Outbox which keeps one JSON file per event in a directory,
+// pending events survive a restart of the service. Records which cannot be read
+// are moved to the quarantine sub directory so they do not block the others.
+type FileOutbox struct {
+	log hclog.Logger
+	mu  sync.Mutex
+	dir string
+}
+
+// quarantineDir is the sub directory of the outbox corrupt records are moved to
+const quarantineDir = "quarantine"
+
+// NewFileOutbox creates a FileOutbox rooted at dir, creating it if needed
+func NewFileOutbox(l hclog.Logger, dir string) (*FileOutbox, error) {
+	p, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(p, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create outbox directory: %w", err)
+	}
+	return &FileOutbox{log: l, dir: p}, nil
+}
+
+// Append writes the event to a new file in the outbox directory
+func (o *FileOutbox) Append(e *Event) error {
+	return o.Update(&Record{Event: e, Delivered: map[string]bool{}})
+}
+
+// Pending reads all records from the outbox directory
+func (o *FileOutbox) Pending() ([]*Record, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	names, err := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	rs := []*Record{}
+	for _, n := range names {
+		d, err := os.ReadFile(n)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read outbox record: %w", err)
+		}
+		r := &Record{}
+		err = json.Unmarshal(d, r)
+		if err == nil && (r.Event == nil || r.Event.ID == "") {
+			err = fmt.Errorf("record has no event")
+		}
+		if err != nil {
+			// one bad record must not stop the delivery of every other event
+			o.log.Error("Unable to decode outbox record, moving it to quarantine", "file", filepath.Base(n), "error", err)
+			o.quarantine(n)
+			continue
+		}
+		if r.Delivered == nil {
+			r.Delivered = map[string]bool{}
+		}
+		rs = append(rs, r)
+	}
+	sortRecords(rs)
+	return rs, nil
+}
+
+// quarantine moves the file of a record which cannot be decoded out of the
+// outbox, it is kept for inspection rather than deleted
+func (o *FileOutbox) quarantine(name string) {
+	qd := filepath.Join(o.dir, quarantineDir)
+	err := os.MkdirAll(qd, os.ModePerm)
+	if err == nil {
+		err = os.Rename(name, filepath.Join(qd, filepath.Base(name)))
+	}
+	if err != nil {
+		o.log.Error("Unable to quarantine outbox record", "file", filepath.Base(name), "error", err)
+	}
+}
+
+// Update replaces the file for the record, the write is atomic so a crash
+// never leaves a half written record behind
+func (o *FileOutbox) Update(r *Record) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	d, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(o.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("unable to create outbox record: %w", err)
+	}
+	_, err = tmp.Write(d)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write outbox record: %w", err)
+	}
+	return os.Rename(tmp.Name(), o.path(r.Event.ID))
+}
+
+// Remove deletes the file for the record
+func (o *FileOutbox) Remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err := os.Remove(o.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (o *FileOutbox) path(id string) string {
+	// ids are generated by us, but never allow them to escape the directory
+	return filepath.Join(o.dir, strings.ReplaceAll(filepath.Base(id), ".", "_")+".json")
+}
+
+// MemoryOutbox is a non durable Outbox, useful for tests
+type MemoryOutbox struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+// NewMemoryOutbox creates an empty MemoryOutbox
+func NewMemoryOutbox() *MemoryOutbox {
+	return &MemoryOutbox{records: map[string]*Record{}}
+}
+
+// Append stores the event in memory
+func (o *MemoryOutbox) Append(e *Event) error {
+	return o.Update(&Record{Event: e, Delivered: map[string]bool{}})
+}
+
+// Pending returns copies of the stored records
+func (o *MemoryOutbox) Pending() ([]*Record, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	rs := []*Record{}
+	for _, r := range o.records {
+		rs = append(rs, copyRecord(r))
+	}
+	sortRecords(rs)
+	return rs, nil
+}
+
+// Update stores a copy of the record
+func (o *MemoryOutbox) Update(r *Record) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.records[r.Event.ID] = copyRecord(r)
+	return nil
+}
+
+// Remove deletes the record
+func (o *MemoryOutbox) Remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.records, id)
+	return nil
+}
+
+func copyRecord(r *Record) *Record {
+	d := map[string]bool{}
+	for k, v := range r.Delivered {
+		d[k] = v
+	}
+	return &Record{Event: r.Event, Delivered: d, Attempts: r.Attempts}
+}
+
+// sortRecords orders records oldest first so events are delivered in the order they happened
+func sortRecords(rs []*Record) {
+	sort.SliceStable(rs, func(i, j int) bool {
+		return rs[i].Event.Time.Before(rs[j].Event.Time)
+	})
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Publisher writes events to an Outbox and delivers them in the background to
+// every Sink, events stay in the outbox until all sinks have accepted them
+type Publisher struct {
+	log      hclog.Logger
+	outbox   Outbox
+	sinks    []Sink
+	interval time.Duration
+	timeout  time.Duration
+	notify   chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewPublisher creates a Publisher and starts delivering any events already
+// pending in the outbox, failed deliveries are retried every interval
+func NewPublisher(l hclog.Logger, o Outbox, interval time.Duration, sinks ...Sink) *Publisher {
+	p := &Publisher{
+		log:      l,
+		outbox:   o,
+		sinks:    sinks,
+		interval: interval,
+		timeout:  30 * time.Second,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish durably stores the event and wakes up the delivery loop,
+// an error means the event has not been stored and will never be delivered
+func (p *Publisher) Publish(e *Event) error {
+	err := p.outbox.Append(e)
+	if err != nil {
+		return err
+	}
+
+	// non blocking, a pending wake up already covers this event
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close stops the delivery loop, pending events are delivered on the next start
+func (p *Publisher) Close() {
+	close(p.done)
+	<-p.stopped
+}
+
+// run delivers pending events whenever one is published or the retry interval elapses
+func (p *Publisher) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.dispatch()
+		select {
+		case <-p.done:
+			return
+		case <-p.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch makes one delivery attempt for every pending event
+func (p *Publisher) dispatch() {
+	rs, err := p.outbox.Pending()
+	if err != nil {
+		p.log.Error("Unable to read pending events from outbox", "error", err)
+		return
+	}
+
+	// a sink which fails is skipped for the rest of the round so that
+	// it never receives events out of order
+	failed := map[string]bool{}
+	for _, r := range rs {
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		r.Attempts++
+		complete := true
+		for _, s := range p.sinks {
+			if r.Delivered[s.Name()] {
+				continue
+			}
+			if failed[s.Name()] {
+				complete = false
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			err := s.Deliver(ctx, r.Event)
+			cancel()
+			if err != nil {
+				p.log.Error("Unable to deliver event", "id", r.Event.ID, "type", r.Event.Type, "sink", s.Name(), "attempts", r.Attempts, "error", err)
+				failed[s.Name()] = true
+				complete = false
+				continue
+			}
+			r.Delivered[s.Name()] = true
+		}
+
+		if complete {
+			err = p.outbox.Remove(r.Event.ID)
+		} else {
+			err = p.outbox.Update(r)
+		}
+		if err != nil {
+			p.log.Error("Unable to update event in outbox", "id", r.Event.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublisherDeliversToChannelSink(t *testing.T) {
+	o := NewMemoryOutbox()
+	cs := NewChannelSink("test", 1)
+	p := NewPublisher(hclog.NewNullLogger(), o, time.Second, cs)
+	defer p.Close()
+
+	e, err := NewEvent(ProductCreated, 1, map[string]string{"name": "Latte"})
+	assert.NoError(t, err)
+	assert.NoError(t, p.Publish(e))
+
+	select {
+	case got := <-cs.Events():
+		assert.Equal(t, e.ID, got.ID)
+		assert.Equal(t, ProductCreated, got.Type)
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+
+	// the outbox is emptied once every sink has the event
+	assert.Eventually(t, func() bool {
+		rs, _ := o.Pending()
+		return len(rs) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWebhookSinkSignsAndRetries(t *testing.T) {
+	secret := "s3cr3t"
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.True(t, webhook.VerifySignature([]byte(secret), body, r.Header.Get(SignatureHeader)))
+		// fail the first attempt to force a retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	ws := NewWebhookSink(ts.URL, secret).WithRetries(2, time.Millisecond)
+	e, _ := NewEvent(ProductDeleted, 2, nil)
+
+	p := NewPublisher(hclog.NewNullLogger(), NewMemoryOutbox(), time.Second, ws)
+	defer p.Close()
+	assert.NoError(t, p.Publish(e))
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestFileOutboxKeepsPendingEvents(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewFileOutbox(hclog.NewNullLogger(), dir)
+	assert.NoError(t, err)
+
+	e, _ := NewEvent(ProductUpdated, 3, nil)
+	assert.NoError(t, o.Append(e))
+
+	// a new outbox on the same directory, as after a restart, still has the event
+	o2, err := NewFileOutbox(hclog.NewNullLogger(), dir)
+	assert.NoError(t, err)
+	rs, err := o2.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, rs, 1)
+	assert.Equal(t, e.ID, rs[0].Event.ID)
+
+	assert.NoError(t, o2.Remove(e.ID))
+	rs, _ = o2.Pending()
+	assert.Len(t, rs, 0)
+}
+
+func TestFileOutboxQuarantinesBadRecords(t *testing.T) {
+	dir := t.TempDir()
+	o, err := NewFileOutbox(hclog.NewNullLogger(), dir)
+	assert.NoError(t, err)
+
+	e, _ := NewEvent(ProductCreated, 1, nil)
+	assert.NoError(t, o.Append(e))
+	// a partial write and a record without an event
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "partial.json"), []byte(`{"event":{"id":`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "empty.json"), []byte(`{}`), 0644))
+
+	rs, err := o.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, rs, 1)
+	assert.Equal(t, e.ID, rs[0].Event.ID)
+
+	// the bad records are kept aside rather than read again
+	assert.FileExists(t, filepath.Join(dir, quarantineDir, "partial.json"))
+	assert.FileExists(t, filepath.Join(dir, quarantineDir, "empty.json"))
+	rs, err = o.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, rs, 1)
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/satoshi-u/go-microservices/webhook"
+)
+
+// Sink delivers events to a downstream consumer
+type Sink interface {
+	// Name identifies the sink in the outbox delivery progress, must be unique per Publisher
+	Name() string
+	// Deliver sends the event, a nil error means the consumer has accepted it
+	Deliver(ctx context.Context, e *Event) error
+}
+
+// SignatureHeader is the header containing the HMAC-SHA256 of the webhook body,
+// the same header and format as the currency rate alert webhooks
+const SignatureHeader = webhook.SignatureHeader
+
+// WebhookSink delivers events as a JSON HTTP POST to a URL, signing the body
+// with HMAC-SHA256 so receivers can verify the sender
+type WebhookSink struct {
+	url    string
+	client *webhook.Client
+}
+
+// NewWebhookSink creates a WebhookSink posting to url, signed with secret
+func NewWebhookSink(url, secret string) *WebhookSink {
+	return &WebhookSink{url: url, client: webhook.NewClient(secret)}
+}
+
+// WithRetries sets the number of retries and the initial backoff between them,
+// the backoff doubles after every failed attempt
+func (w *WebhookSink) WithRetries(maxRetries int, backoff time.Duration) *WebhookSink {
+	w.client.WithRetries(maxRetries, backoff)
+	return w
+}
+
+// Name returns the webhook URL
+func (w *WebhookSink) Name() string {
+	return "webhook:" + w.url
+}
+
+// Deliver posts the event, retrying on network errors, 429 and 5xx responses
+func (w *WebhookSink) Deliver(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return w.client.Post(ctx, w.url, http.Header{"X-Event-ID": {e.ID}, "X-Event-Type": {e.Type}}, body)
+}
+
+// ChannelSink delivers events to an in-process channel, useful for tests
+type ChannelSink struct {
+	name string
+	ch   chan *Event
+}
+
+// NewChannelSink creates a ChannelSink with the given buffer size
+func NewChannelSink(name string, buffer int) *ChannelSink {
+	return &ChannelSink{name: name, ch: make(chan *Event, buffer)}
+}
+
+// Name returns the name given to NewChannelSink
+func (c *ChannelSink) Name() string {
+	return c.name
+}
+
+// Events returns the channel events are delivered to
+func (c *ChannelSink) Events() <-chan *Event {
+	return c.ch
+}
+
+// Deliver blocks until the event has been buffered or read, or ctx is done
+func (c *ChannelSink) Deliver(ctx context.Context, e *Event) error {
+	select {
+	case c.ch <- e:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
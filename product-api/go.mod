@@ -1,6 +1,6 @@
 module github.com/satoshi-u/go-microservices/product-api
 
-go 1.18
+go 1.20
 
 require (
 	github.com/go-openapi/errors v0.20.3
@@ -11,13 +11,13 @@ require (
 	github.com/go-playground/validator v9.31.0+incompatible
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/satoshi-u/go-microservices/currency v0.0.0-20230315154703-94ee3d80b7e1
+	github.com/satoshi-u/go-microservices/webhook v0.0.0-00010101000000-000000000000
 	github.com/stretchr/testify v1.8.0
 	google.golang.org/grpc v1.53.0
 )
 
-require github.com/cloudflare/cfssl v1.6.2 // indirect
-
 require (
 	github.com/PuerkitoBio/purell v1.1.1 // indirect
 	github.com/PuerkitoBio/urlesc v0.0.0-20170810143723-de5bf2ad4578 // indirect
@@ -33,7 +33,6 @@ require (
 	github.com/go-playground/locales v0.14.0 // indirect
 	github.com/go-playground/universal-translator v0.18.0 // indirect
 	github.com/golang/protobuf v1.5.2 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/hashicorp/go-hclog v1.3.0
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/kr/pretty v0.2.1 // indirect
@@ -51,8 +50,12 @@ require (
 	golang.org/x/sys v0.4.0 // indirect
 	golang.org/x/text v0.6.0 // indirect
 	google.golang.org/genproto v0.0.0-20230110181048-76db0878b65f // indirect
-	google.golang.org/protobuf v1.28.1 // indirect
+	google.golang.org/protobuf v1.28.1
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/satoshi-u/go-microservices/currency => ../currency
+
+replace github.com/satoshi-u/go-microservices/webhook => ../webhook
@@ -71,7 +71,7 @@ type productParamsWrapper struct {
 // swagger:parameters getProducts getProduct
 type productQueryParam struct {
 	// Currency used when returning the price of the product,
-	// when none specified, price is returned in EUR.
+	// when none specified, price is returned in the base currency (EUR by default).
 	// in: query
 	// required: false
 	Currency string
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/satoshi-u/go-microservices/product-api/data"
@@ -12,6 +13,7 @@ import (
 //
 //     Responses:
 //       200: productsResponse
+//       400: errorResponse
 //       500: errorResponse
 
 // GetProducts handles GET requests and returns all current products
@@ -26,7 +28,7 @@ func (p *Products) GetProducts(rw http.ResponseWriter, r *http.Request) {
 	// Getting products from data package
 	prods, err := p.pdb.GetProducts(cur)
 	if err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
+		rw.WriteHeader(currencyErrorStatus(err))
 		data.ToJSON(&GenericError{Message: err.Error()}, rw)
 		return
 	}
@@ -89,9 +91,14 @@ func (p *Products) GetProduct(rw http.ResponseWriter, r *http.Request) {
 	prod, err := p.pdb.GetProductByID(id, cur)
 
 	// handle types of errors
-	switch err {
-	case nil:
-	case data.ErrProductNotFound:
+	switch {
+	case err == nil:
+	case errors.Is(err, data.ErrInvalidCurrency):
+		p.l.Error("invalid currency", "currency", cur, "error", err)
+		rw.WriteHeader(http.StatusBadRequest)
+		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		return
+	case err == data.ErrProductNotFound:
 		p.l.Error("product not found", "error", err)
 		rw.WriteHeader(http.StatusNotFound)
 		data.ToJSON(&GenericError{Message: err.Error()}, rw)
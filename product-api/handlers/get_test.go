@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGetProductsCurrencyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cc   *stubCurrency
+		url  string
+		code int
+	}{
+		{"known currency", &stubCurrency{rates: map[string]float64{"USD": 1.1}}, "/products?currency=USD", http.StatusOK},
+		{"unknown currency", &stubCurrency{rates: map[string]float64{"USD": 1.1}}, "/products?currency=XXX", http.StatusBadRequest},
+		{"currency service down", &stubCurrency{err: status.Error(codes.Unavailable, "connection refused")}, "/products?currency=USD", http.StatusInternalServerError},
+		{"product in unknown currency", &stubCurrency{rates: map[string]float64{"USD": 1.1}}, "/products/1?currency=XXX", http.StatusBadRequest},
+		{"product with currency service down", &stubCurrency{err: status.Error(codes.Unavailable, "connection refused")}, "/products/1?currency=USD", http.StatusInternalServerError},
+		{"missing product", &stubCurrency{}, "/products/99", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sm, _ := setupProducts(t, tc.cc)
+
+			rw := httptest.NewRecorder()
+			sm.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tc.url, nil))
+			assert.Equal(t, tc.code, rw.Code)
+			assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+			if tc.code != http.StatusOK {
+				assert.Contains(t, rw.Body.String(), `"message"`)
+			}
+		})
+	}
+}
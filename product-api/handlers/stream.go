@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/satoshi-u/go-microservices/product-api/data"
+)
+
+// heartbeatInterval is how often a comment is sent on idle streams so that
+// proxies and browsers do not close the connection
+const heartbeatInterval = 15 * time.Second
+
+// swagger:route GET /products/stream products streamProducts
+//
+// Streams the list of products as Server-Sent Events, a "products" event
+// is sent on connect and whenever a product or the rate for the currency changes
+//
+//     Produces:
+//     - text/event-stream
+//
+//     Responses:
+//       200: productsResponse
+//       400: errorResponse
+//       500: errorResponse
+
+// StreamProducts handles GET requests and streams products as Server-Sent Events
+func (p *Products) StreamProducts(rw http.ResponseWriter, r *http.Request) {
+	p.l.Debug("Handle Products STREAM ****** START ******")
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusInternalServerError)
+		data.ToJSON(&GenericError{Message: "streaming is not supported"}, rw)
+		return
+	}
+
+	// get preferred currency if it exists, rate updates are for upper case codes
+	cur := strings.ToUpper(r.URL.Query().Get("currency"))
+
+	// watch before reading the products so no change is missed in between
+	changes, stop := p.pdb.Watch()
+	defer stop()
+
+	prods, err := p.pdb.GetProducts(cur)
+	if err != nil {
+		rw.Header().Add("Content-Type", "application/json")
+		rw.WriteHeader(currencyErrorStatus(err))
+		data.ToJSON(&GenericError{Message: err.Error()}, rw)
+		return
+	}
+
+	// the stream outlives the server WriteTimeout, clear the deadline for this response
+	err = http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	if err != nil {
+		p.l.Error("Unable to clear write deadline for stream", "error", err)
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+
+	id := 0
+	send := func(prods data.Products) error {
+		id++
+		return writeEvent(rw, flusher, id, "products", prods)
+	}
+	if err := send(prods); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			p.l.Debug("Handle Products STREAM ****** END ******")
+			return
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(rw, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case c := <-changes:
+			// rate changes only matter for the currency of this stream
+			if c.Type == data.ChangeRateUpdated && c.Currency != cur {
+				continue
+			}
+			prods, err := p.pdb.GetProducts(cur)
+			if err != nil {
+				p.l.Error("Unable to get products for stream", "currency", cur, "error", err)
+				continue
+			}
+			if err := send(prods); err != nil {
+				p.l.Debug("Unable to write to stream, closing", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with JSON data and flushes it to the client
+func writeEvent(rw http.ResponseWriter, f http.Flusher, id int, event string, v interface{}) error {
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", id, event, d)
+	if err != nil {
+		return err
+	}
+	f.Flush()
+	return nil
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/currency/pb"
+	"github.com/satoshi-u/go-microservices/product-api/data"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubCurrency is a pb.CurrencyClient for tests, the methods which are not set panic
+type stubCurrency struct {
+	pb.CurrencyClient
+	// rates from EUR, other currencies are invalid
+	rates map[string]float64
+	// err is returned for every rate when set
+	err error
+	// updates are streamed by SubscribeRates when set
+	updates chan *pb.StreamingRateResponse
+	// calls counts the calls of GetRate
+	calls int32
+}
+
+// GetRate returns the stub rates
+func (s *stubCurrency) GetRate(ctx context.Context, in *pb.RateRequest, opts ...grpc.CallOption) (*pb.RateResponse, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if s.err != nil {
+		return nil, s.err
+	}
+	rate, ok := s.rates[in.GetDestination()]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown currency %s", in.GetDestination())
+	}
+	return &pb.RateResponse{Base: in.GetBase(), Destination: in.GetDestination(), Rate: rate}, nil
+}
+
+// SubscribeRates streams the updates, without them it fails so ProductsDB runs without rate updates
+func (s *stubCurrency) SubscribeRates(ctx context.Context, opts ...grpc.CallOption) (pb.Currency_SubscribeRatesClient, error) {
+	if s.updates == nil {
+		return nil, status.Error(codes.Unavailable, "no rate updates in tests")
+	}
+	return &stubRateStream{updates: s.updates}, nil
+}
+
+// stubRateStream accepts every subscription and receives the updates of stubCurrency
+type stubRateStream struct {
+	grpc.ClientStream
+	updates chan *pb.StreamingRateResponse
+}
+
+func (s *stubRateStream) Send(rr *pb.RateRequest) error {
+	return nil
+}
+
+func (s *stubRateStream) Recv() (*pb.StreamingRateResponse, error) {
+	rr, ok := <-s.updates
+	if !ok {
+		return nil, io.EOF
+	}
+	return rr, nil
+}
+
+func setupProducts(t *testing.T, cc pb.CurrencyClient) (*mux.Router, *data.ProductsDB) {
+	pdb := data.NewProductsDB(cc, hclog.NewNullLogger(), "EUR", nil)
+	ph := NewProducts(hclog.NewNullLogger(), data.NewValidation(), pdb)
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/products", ph.GetProducts).Methods(http.MethodGet)
+	sm.HandleFunc("/products/stream", ph.StreamProducts).Methods(http.MethodGet)
+	sm.HandleFunc("/products/{id:[0-9]+}", ph.GetProduct).Methods(http.MethodGet)
+	return sm, pdb
+}
+
+// readEvent reads the next Server-Sent Event, skipping heartbeats
+func readEvent(r *bufio.Reader) (map[string]string, error) {
+	ev := map[string]string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(ev) > 0 {
+				return ev, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		kv := strings.SplitN(line, ": ", 2)
+		ev[kv[0]] = kv[1]
+	}
+}
+
+func TestStreamProductsSendsChanges(t *testing.T) {
+	sm, pdb := setupProducts(t, &stubCurrency{})
+	ts := httptest.NewServer(sm)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/products/stream", nil)
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	br := bufio.NewReader(resp.Body)
+	ev, err := readEvent(br)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", ev["id"])
+	assert.Equal(t, "products", ev["event"])
+	assert.Contains(t, ev["data"], `"name":"Latte"`)
+
+	// every product change sends the list again
+	p := pdb.AddProduct(&data.Product{Name: "Mocha", Price: 3.10, SKU: "prod-bev-003"})
+	defer pdb.DeleteProduct(p.ID)
+	done := make(chan map[string]string)
+	go func() {
+		ev, _ := readEvent(br)
+		done <- ev
+	}()
+	select {
+	case ev = <-done:
+		assert.Equal(t, "2", ev["id"])
+		assert.Contains(t, ev["data"], `"name":"Mocha"`)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event for the new product")
+	}
+}
+
+func TestStreamProductsRateUpdatesAnyCase(t *testing.T) {
+	cc := &stubCurrency{rates: map[string]float64{"USD": 2}, updates: make(chan *pb.StreamingRateResponse)}
+	sm, _ := setupProducts(t, cc)
+	ts := httptest.NewServer(sm)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/products/stream?currency=usd", nil)
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	br := bufio.NewReader(resp.Body)
+	ev, err := readEvent(br)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", ev["id"])
+
+	// the update is for USD, the stream asked for usd
+	cc.updates <- &pb.StreamingRateResponse{Message: &pb.StreamingRateResponse_RateResponse{
+		RateResponse: &pb.RateResponse{Base: "EUR", Destination: "USD", Rate: 3},
+	}}
+	done := make(chan map[string]string)
+	go func() {
+		ev, _ := readEvent(br)
+		done <- ev
+	}()
+	select {
+	case ev = <-done:
+		assert.Equal(t, "2", ev["id"])
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event for the rate update")
+	}
+}
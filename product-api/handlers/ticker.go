@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/product-api/data"
+)
+
+const (
+	// time allowed to write a message to the client
+	tickerWriteWait = 10 * time.Second
+	// time allowed between pongs from the client before the connection is closed
+	tickerPongWait = 60 * time.Second
+	// pings are sent with this period, must be less than tickerPongWait
+	tickerPingPeriod = (tickerPongWait * 9) / 10
+	// maximum size of a message from the client
+	tickerMaxMessageSize = 4096
+	// maximum number of currencies a single connection can subscribe to
+	tickerMaxCurrencies = 16
+	// number of control messages (acks, errors) buffered before a client is considered stuck
+	tickerControlBuffer = 8
+)
+
+// currencyCode matches ISO 4217 currency codes once upper cased
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Actions a client can send on the ticker connection
+const (
+	TickerSubscribe   = "subscribe"
+	TickerUnsubscribe = "unsubscribe"
+)
+
+// TickerRequest is sent by a client to change its subscriptions, an empty
+// Products list subscribes to all products
+type TickerRequest struct {
+	Action     string   `json:"action"`
+	Currencies []string `json:"currencies"`
+	Products   []int    `json:"products"`
+}
+
+// TickerMessage is sent to a client, Type is one of "prices", "subscriptions" or "error"
+type TickerMessage struct {
+	Type string `json:"type"`
+	// Currency and Products are set for prices
+	Currency string        `json:"currency,omitempty"`
+	Products data.Products `json:"products,omitempty"`
+	// Currencies and ProductIDs are the active subscriptions after a request
+	Currencies []string `json:"currencies,omitempty"`
+	ProductIDs []int    `json:"product_ids,omitempty"`
+	// Message is set for errors
+	Message string `json:"message,omitempty"`
+}
+
+// Ticker is a http.Handler serving live prices over a WebSocket, every
+// connection shares the single rate subscription stream held by ProductsDB
+type Ticker struct {
+	l        hclog.Logger
+	pdb      *data.ProductsDB
+	upgrader websocket.Upgrader
+}
+
+// NewTicker creates a Ticker accepting connections from the same origin or any of origins
+func NewTicker(l hclog.Logger, pdb *data.ProductsDB, origins []string) *Ticker {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		allowed[o] = true
+	}
+	return &Ticker{
+		l:   l,
+		pdb: pdb,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin: func(r *http.Request) bool {
+				o := r.Header.Get("Origin")
+				return o == "" || allowed[o] || strings.EqualFold(strings.TrimPrefix(strings.TrimPrefix(o, "http://"), "https://"), r.Host)
+			},
+		},
+	}
+}
+
+// swagger:route GET /products/ticker products productsTicker
+//
+// Upgrades to a WebSocket streaming product prices, send
+// {"action": "subscribe", "currencies": ["INR"], "products": [1]} to receive
+// {"type": "prices", "currency": "INR", "products": [...]} whenever they change
+//
+//     Responses:
+//       101: noContentResponse
+//       400: errorResponse
+
+// ServeHTTP upgrades the request to a WebSocket and serves price updates until the client leaves
+func (t *Ticker) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	ws, err := t.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// the upgrader has already replied to the client
+		t.l.Error("Unable to upgrade ticker connection", "error", err)
+		return
+	}
+	t.l.Debug("Ticker connection opened", "remote", r.RemoteAddr)
+
+	c := &tickerConn{
+		l:          t.l,
+		pdb:        t.pdb,
+		ws:         ws,
+		currencies: map[string]bool{},
+		products:   map[int]bool{},
+		pending:    map[string]*TickerMessage{},
+		ready:      make(chan struct{}, 1),
+		control:    make(chan *TickerMessage, tickerControlBuffer),
+		done:       make(chan struct{}),
+	}
+
+	// watch before accepting subscriptions so that no change is missed
+	changes, stop := t.pdb.Watch()
+	defer stop()
+
+	go c.writeLoop()
+	go c.readLoop()
+
+	for {
+		select {
+		case <-c.done:
+			t.l.Debug("Ticker connection closed", "remote", r.RemoteAddr)
+			return
+		case ch := <-changes:
+			switch ch.Type {
+			case data.ChangeRateUpdated:
+				if c.subscribed(ch.Currency) {
+					c.refresh(ch.Currency)
+				}
+			default:
+				if c.watchesProduct(ch.Product.ID) {
+					for _, cur := range c.subscriptions() {
+						c.refresh(cur)
+					}
+				}
+			}
+		}
+	}
+}
+
+// tickerConn is the state of a single ticker connection
+type tickerConn struct {
+	l   hclog.Logger
+	pdb *data.ProductsDB
+	ws  *websocket.Conn
+
+	mu         sync.Mutex
+	currencies map[string]bool
+	products   map[int]bool
+	// pending holds the latest unsent prices per currency, a client which is
+	// slower than the updates only ever receives the most recent prices
+	pending map[string]*TickerMessage
+
+	ready   chan struct{}       // signals the writer that pending has messages
+	control chan *TickerMessage // acks and errors, never coalesced
+	done    chan struct{}
+	once    sync.Once
+}
+
+// close shuts the connection down, safe to call from any goroutine
+func (c *tickerConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+// readLoop handles subscription requests and pongs from the client
+func (c *tickerConn) readLoop() {
+	defer c.close()
+
+	c.ws.SetReadLimit(tickerMaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(tickerPongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(tickerPongWait))
+	})
+
+	for {
+		req := &TickerRequest{}
+		err := c.ws.ReadJSON(req)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.l.Error("Unable to read from ticker connection", "error", err)
+			}
+			return
+		}
+		c.handle(req)
+	}
+}
+
+// handle applies a subscription request and acknowledges it
+func (c *tickerConn) handle(req *TickerRequest) {
+	// prices of the new currencies, sent after the ack
+	added := map[string]data.Products{}
+
+	switch req.Action {
+	case TickerSubscribe:
+		// the whole request is checked before any price is fetched, fetching the
+		// prices subscribes ProductsDB to rate updates for the currency
+		curs := []string{}
+		seen := map[string]bool{}
+		for _, cur := range req.Currencies {
+			cur = strings.ToUpper(cur)
+			if seen[cur] || c.subscribed(cur) {
+				continue
+			}
+			seen[cur] = true
+			if !currencyCode.MatchString(cur) {
+				c.sendControl(&TickerMessage{Type: "error", Currency: cur, Message: "invalid currency code " + cur})
+				continue
+			}
+			curs = append(curs, cur)
+		}
+		if len(c.subscriptions())+len(curs) > tickerMaxCurrencies {
+			c.sendControl(&TickerMessage{Type: "error", Message: "too many currency subscriptions"})
+			return
+		}
+
+		// unknown currencies are rejected by the currency service, they never
+		// take a subscription slot or show up in the ack
+		for _, cur := range curs {
+			prods, err := c.pdb.GetProducts(cur)
+			if errors.Is(err, data.ErrInvalidCurrency) {
+				c.sendControl(&TickerMessage{Type: "error", Currency: cur, Message: err.Error()})
+				continue
+			}
+			if err != nil {
+				// the currency service is unreachable, prices follow once it is back
+				c.l.Error("Unable to get products for ticker", "currency", cur, "error", err)
+			}
+			added[cur] = prods
+		}
+
+		// only the read loop changes the subscriptions, the limit still holds
+		c.mu.Lock()
+		for cur := range added {
+			c.currencies[cur] = true
+		}
+		for _, id := range req.Products {
+			c.products[id] = true
+		}
+	case TickerUnsubscribe:
+		c.mu.Lock()
+		for _, cur := range req.Currencies {
+			cur = strings.ToUpper(cur)
+			delete(c.currencies, cur)
+			delete(c.pending, cur)
+		}
+		for _, id := range req.Products {
+			delete(c.products, id)
+		}
+	default:
+		c.sendControl(&TickerMessage{Type: "error", Message: "unknown action " + req.Action})
+		return
+	}
+	ack := &TickerMessage{Type: "subscriptions", Currencies: c.subscriptionsLocked(), ProductIDs: c.productIDsLocked()}
+	c.mu.Unlock()
+
+	c.sendControl(ack)
+
+	// new currencies get their current prices straight away, getting them also
+	// subscribed ProductsDB to rate updates for the currency
+	for cur, prods := range added {
+		if prods == nil {
+			c.refresh(cur)
+			continue
+		}
+		c.queue(cur, prods)
+	}
+}
+
+// refresh queues the current prices for the currency, replacing any unsent prices
+func (c *tickerConn) refresh(cur string) {
+	prods, err := c.pdb.GetProducts(cur)
+	if err != nil {
+		c.l.Error("Unable to get products for ticker", "currency", cur, "error", err)
+		c.sendControl(&TickerMessage{Type: "error", Currency: cur, Message: err.Error()})
+		return
+	}
+	c.queue(cur, prods)
+}
+
+// queue sets the prices of the products the client watches as the next
+// message for the currency and wakes up the writer
+func (c *tickerConn) queue(cur string, prods data.Products) {
+	c.mu.Lock()
+	if !c.currencies[cur] {
+		// unsubscribed in the meantime
+		c.mu.Unlock()
+		return
+	}
+	filtered := data.Products{}
+	for _, p := range prods {
+		if len(c.products) == 0 || c.products[p.ID] {
+			filtered = append(filtered, p)
+		}
+	}
+	c.pending[cur] = &TickerMessage{Type: "prices", Currency: cur, Products: filtered}
+	c.mu.Unlock()
+
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+// sendControl queues a message which must not be dropped, a client which
+// does not read its control messages is disconnected
+func (c *tickerConn) sendControl(m *TickerMessage) {
+	select {
+	case c.control <- m:
+	case <-c.done:
+	default:
+		c.l.Error("Ticker client is not reading, closing connection")
+		c.close()
+	}
+}
+
+// writeLoop writes queued messages and pings to the client
+func (c *tickerConn) writeLoop() {
+	ping := time.NewTicker(tickerPingPeriod)
+	defer ping.Stop()
+	defer c.close()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case m := <-c.control:
+			if c.write(m) != nil {
+				return
+			}
+		case <-c.ready:
+			// acks go first, so clients get the subscription before its prices
+			for len(c.control) > 0 {
+				if c.write(<-c.control) != nil {
+					return
+				}
+			}
+			c.mu.Lock()
+			msgs := c.pending
+			c.pending = map[string]*TickerMessage{}
+			c.mu.Unlock()
+			for _, m := range msgs {
+				if c.write(m) != nil {
+					return
+				}
+			}
+		case <-ping.C:
+			c.ws.SetWriteDeadline(time.Now().Add(tickerWriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *tickerConn) write(m *TickerMessage) error {
+	c.ws.SetWriteDeadline(time.Now().Add(tickerWriteWait))
+	err := c.ws.WriteJSON(m)
+	if err != nil {
+		c.l.Debug("Unable to write to ticker connection", "error", err)
+	}
+	return err
+}
+
+func (c *tickerConn) subscribed(cur string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.currencies[cur]
+}
+
+func (c *tickerConn) watchesProduct(id int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.products) == 0 || c.products[id]
+}
+
+func (c *tickerConn) subscriptions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.subscriptionsLocked()
+}
+
+func (c *tickerConn) subscriptionsLocked() []string {
+	curs := []string{}
+	for cur := range c.currencies {
+		curs = append(curs, cur)
+	}
+	sort.Strings(curs)
+	return curs
+}
+
+func (c *tickerConn) productIDsLocked() []int {
+	ids := []int{}
+	for id := range c.products {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
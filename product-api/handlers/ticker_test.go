@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/product-api/data"
+	"github.com/stretchr/testify/assert"
+)
+
+// dialTicker starts a Ticker and connects a client to it
+func dialTicker(t *testing.T, pdb *data.ProductsDB) *websocket.Conn {
+	ts := httptest.NewServer(NewTicker(hclog.NewNullLogger(), pdb, nil))
+	t.Cleanup(ts.Close)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("unable to connect to ticker: %s", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func readTicker(t *testing.T, ws *websocket.Conn) *TickerMessage {
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	m := &TickerMessage{}
+	err := ws.ReadJSON(m)
+	if err != nil {
+		t.Fatalf("unable to read from ticker: %s", err)
+	}
+	return m
+}
+
+func TestTickerSubscribeAndUnsubscribe(t *testing.T) {
+	_, pdb := setupProducts(t, &stubCurrency{rates: map[string]float64{"INR": 100}})
+	ws := dialTicker(t, pdb)
+
+	err := ws.WriteJSON(&TickerRequest{Action: TickerSubscribe, Currencies: []string{"inr", "xxx"}, Products: []int{1}})
+	assert.NoError(t, err)
+
+	// the unknown currency is rejected and never subscribed
+	m := readTicker(t, ws)
+	assert.Equal(t, "error", m.Type)
+	assert.Equal(t, "XXX", m.Currency)
+
+	m = readTicker(t, ws)
+	assert.Equal(t, "subscriptions", m.Type)
+	assert.Equal(t, []string{"INR"}, m.Currencies)
+	assert.Equal(t, []int{1}, m.ProductIDs)
+
+	m = readTicker(t, ws)
+	assert.Equal(t, "prices", m.Type)
+	assert.Equal(t, "INR", m.Currency)
+	if assert.Len(t, m.Products, 1) {
+		assert.Equal(t, 1, m.Products[0].ID)
+		assert.Equal(t, 245.0, m.Products[0].Price)
+	}
+
+	err = ws.WriteJSON(&TickerRequest{Action: TickerUnsubscribe, Currencies: []string{"INR"}, Products: []int{1}})
+	assert.NoError(t, err)
+	m = readTicker(t, ws)
+	assert.Equal(t, "subscriptions", m.Type)
+	assert.Empty(t, m.Currencies)
+	assert.Empty(t, m.ProductIDs)
+
+	err = ws.WriteJSON(&TickerRequest{Action: "dance"})
+	assert.NoError(t, err)
+	m = readTicker(t, ws)
+	assert.Equal(t, "error", m.Type)
+	assert.Equal(t, "unknown action dance", m.Message)
+}
+
+func TestTickerRejectsRequestsOverTheLimit(t *testing.T) {
+	cc := &stubCurrency{rates: map[string]float64{"INR": 100}}
+	_, pdb := setupProducts(t, cc)
+	ws := dialTicker(t, pdb)
+
+	curs := []string{}
+	for i := 0; i <= tickerMaxCurrencies; i++ {
+		curs = append(curs, string(rune('A'+i))+"AA")
+	}
+	err := ws.WriteJSON(&TickerRequest{Action: TickerSubscribe, Currencies: append(curs, "usd1")})
+	assert.NoError(t, err)
+
+	// the malformed code is rejected, then the whole request without asking the currency service
+	m := readTicker(t, ws)
+	assert.Equal(t, "error", m.Type)
+	assert.Equal(t, "USD1", m.Currency)
+	m = readTicker(t, ws)
+	assert.Equal(t, "error", m.Type)
+	assert.Equal(t, "too many currency subscriptions", m.Message)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&cc.calls))
+
+	// nothing of the rejected request was applied
+	err = ws.WriteJSON(&TickerRequest{Action: TickerSubscribe, Currencies: []string{"INR"}})
+	assert.NoError(t, err)
+	m = readTicker(t, ws)
+	assert.Equal(t, "subscriptions", m.Type)
+	assert.Equal(t, []string{"INR"}, m.Currencies)
+}
+
+// newTestTickerConn returns a tickerConn whose writer is not running, over a real WebSocket
+func newTestTickerConn(t *testing.T, pdb *data.ProductsDB) *tickerConn {
+	conns := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(rw, r, nil)
+		if err == nil {
+			conns <- ws
+		}
+	}))
+	t.Cleanup(ts.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return &tickerConn{
+		l:          hclog.NewNullLogger(),
+		pdb:        pdb,
+		ws:         <-conns,
+		currencies: map[string]bool{},
+		products:   map[int]bool{},
+		pending:    map[string]*TickerMessage{},
+		ready:      make(chan struct{}, 1),
+		control:    make(chan *TickerMessage, tickerControlBuffer),
+		done:       make(chan struct{}),
+	}
+}
+
+func TestTickerCoalescesPricesForSlowClients(t *testing.T) {
+	_, pdb := setupProducts(t, &stubCurrency{rates: map[string]float64{"INR": 100}})
+	c := newTestTickerConn(t, pdb)
+	c.currencies["INR"] = true
+	c.products[2] = true
+
+	// the writer is not running, as for a client which does not keep up
+	c.queue("INR", data.Products{{ID: 2, Price: 1}})
+	c.queue("INR", data.Products{{ID: 1, Price: 5}, {ID: 2, Price: 2}})
+	c.refresh("INR")
+
+	// only the latest prices are kept, with a single wake up for the writer
+	assert.Len(t, c.pending, 1)
+	assert.Len(t, c.ready, 1)
+	m := c.pending["INR"]
+	if assert.Len(t, m.Products, 1) {
+		assert.Equal(t, 2, m.Products[0].ID)
+		assert.Equal(t, 199.0, m.Products[0].Price)
+	}
+
+	// prices of unsubscribed currencies are dropped
+	c.queue("USD", data.Products{{ID: 2, Price: 1}})
+	assert.Len(t, c.pending, 1)
+}
+
+func TestTickerClosesStuckClients(t *testing.T) {
+	_, pdb := setupProducts(t, &stubCurrency{})
+	c := newTestTickerConn(t, pdb)
+
+	for i := 0; i < tickerControlBuffer; i++ {
+		c.sendControl(&TickerMessage{Type: "subscriptions"})
+	}
+	select {
+	case <-c.done:
+		t.Fatal("closed before the control buffer was full")
+	default:
+	}
+
+	// the client has not read any of its acks
+	c.sendControl(&TickerMessage{Type: "subscriptions"})
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("stuck client was not closed")
+	}
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -28,3 +29,12 @@ func getProductID(rw http.ResponseWriter, r *http.Request) int {
 	}
 	return id
 }
+
+// currencyErrorStatus returns the status code for an error getting prices,
+// 400 for currencies the currency service does not know and 500 otherwise
+func currencyErrorStatus(err error) int {
+	if errors.Is(err, data.ErrInvalidCurrency) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
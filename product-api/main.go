@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,9 +17,13 @@ import (
 	"github.com/nicholasjackson/env"
 	"github.com/satoshi-u/go-microservices/currency/pb"
 	"github.com/satoshi-u/go-microservices/product-api/data"
+	"github.com/satoshi-u/go-microservices/product-api/events"
 	"github.com/satoshi-u/go-microservices/product-api/handlers"
+	productpb "github.com/satoshi-u/go-microservices/product-api/pb"
+	"github.com/satoshi-u/go-microservices/product-api/server"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
 )
 
 // go mod init github.com/satoshi-u/go-microservices/product-api
@@ -28,15 +34,29 @@ import (
 // GET     -> curl -v "localhost:9090/products?currency=INR" | jq
 // GET     -> curl -v localhost:9090/products/2 | jq
 // GET     -> curl -v "localhost:9090/products/2?currency=INR" | jq
+// SSE     -> curl -N "localhost:9090/products/stream?currency=INR"
+// WS      -> websocat ws://localhost:9090/products/ticker , then send {"action": "subscribe", "currencies": ["INR", "USD"], "products": [1]}
 // POST    -> curl -v localhost:9090/products -d '{"name": "Indian Tea", "description": "nice cup of tea", "price": 3.14, "sku": "prod-bev-003"}'| jq
 // POST    -> curl -v localhost:9090/products -d '{"name": "coffee $1", "description": "cheap coffee", "price": 1.00, "sku": "prod-bev-004"}'| jq
 // PUT   	 -> curl -v localhost:9090/products -XPUT -d '{"id": 1, "name": "Cappuccino", "description": "steamed milk foam", "price": 5.00, "sku": "prod-bev-001"}'| jq
 // DELETE  -> curl -v localhost:9090/products/4 -XDELETE | jq
+// gRPC    -> grpcurl --plaintext localhost:9093 list products.ProductService
+// gRPC    -> grpcurl --plaintext -d '{"Currency":"INR"}' localhost:9093 products.ProductService.ListProducts
+// gRPC    -> grpcurl --plaintext -d '{"ID":1}' localhost:9093 products.ProductService.GetProduct
+// gRPC    -> grpcurl --plaintext -d '{"Product":{"Name":"Tea","Price":2.5,"SKU":"prod-bev-005"}}' localhost:9093 products.ProductService.CreateProduct
+// gRPC    -> grpcurl --plaintext -d '{"Currency":"INR"}' localhost:9093 products.ProductService.WatchProducts
 
 // create swagger.yaml       -> make swagger
+// codegen from protos       -> make protos
 // codegen from swagger.yaml -> mkdir sdk && cd sdk && swagger generate client -f ../swagger.yaml -A product-api
 
 var bindAddress = env.String("BIND_ADDRESS", false, ":9090", "Bind address for the server")
+var grpcBindAddress = env.String("GRPC_BIND_ADDRESS", false, ":9093", "Bind address for the gRPC server")
+var baseCurrency = env.String("BASE_CURRENCY", false, "EUR", "Currency the product prices are stored in, prices in other currencies are converted from it")
+var currencyTimeout = env.Duration("CURRENCY_STARTUP_TIMEOUT", false, 30*time.Second, "Time to wait for the currency server to check the base currency, the server starts without waiting")
+var outboxPath = env.String("EVENTS_OUTBOX_PATH", false, "./outbox", "Directory to persist product events until they are delivered")
+var webhookURL = env.String("EVENTS_WEBHOOK_URL", false, "", "URL to POST product events to, events are not delivered when empty")
+var webhookSecret = env.String("EVENTS_WEBHOOK_SECRET", false, "", "Secret used to sign the HMAC-SHA256 of product event webhooks")
 
 func main() {
 
@@ -54,10 +74,40 @@ func main() {
 	}
 	defer conn.Close()
 	cc := pb.NewCurrencyClient(conn)
+	// prices are stored in the base currency, every conversion fails when it is unknown. The
+	// check runs in the background so products in the base currency are served while the
+	// currency server is down or still starting.
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), *currencyTimeout)
+		defer cancel()
+		err := data.CheckCurrency(ctx, cc, *baseCurrency)
+		switch {
+		case errors.Is(err, data.ErrInvalidCurrency):
+			l.Error("Base currency is unknown to the currency server, price conversions will fail", "currency", *baseCurrency, "error", err)
+		case err != nil:
+			l.Warn("Unable to check base currency", "currency", *baseCurrency, "error", err)
+		}
+	}()
+	// domain events : outbox on disk, delivered to the webhook when configured
+	ob, err := events.NewFileOutbox(l.Named("outbox"), *outboxPath)
+	if err != nil {
+		l.Error("Unable to create events outbox", "error", err)
+		os.Exit(1)
+	}
+	sinks := []events.Sink{}
+	if *webhookURL != "" {
+		sinks = append(sinks, events.NewWebhookSink(*webhookURL, *webhookSecret))
+	}
+	ep := events.NewPublisher(l.Named("events"), ob, 10*time.Second, sinks...)
+	defer ep.Close()
 	// ProductsDB instance
-	pdb := data.NewProductsDB(cc, l)
+	pdb := data.NewProductsDB(cc, l, *baseCurrency, ep)
 	// handler instantiate with constructor dependency injection : logger, validation, ProductsDB
 	ph := handlers.NewProducts(l, v, pdb)
+	// origins allowed to call the API from a browser
+	origins := []string{"http://localhost:3000"} // "http://localhost:3000"   *
+	// websocket price ticker, shares the rate subscription held by ProductsDB
+	th := handlers.NewTicker(l.Named("ticker"), pdb, origins)
 	// hh := handlers.NewHello(l)
 
 	// new std lib mux : create mux and register handlers
@@ -70,6 +120,8 @@ func main() {
 	getRouter := sm.Methods(http.MethodGet).Subrouter()
 	getRouter.HandleFunc("/products", ph.GetProducts)
 	getRouter.HandleFunc("/products", ph.GetProducts).Queries("currency", "{[A-Z]{3}}")
+	getRouter.HandleFunc("/products/stream", ph.StreamProducts)
+	getRouter.Handle("/products/ticker", th)
 	getRouter.HandleFunc("/products/{id:[0-9]+}", ph.GetProduct)
 	getRouter.HandleFunc("/products/{id:[0-9]+}", ph.GetProduct).Queries("currency", "{[A-Z]{3}}")
 
@@ -93,7 +145,7 @@ func main() {
 	getRouter.HandleFunc("/swagger.yaml", http.FileServer(http.Dir("./")).ServeHTTP)
 
 	// CORS
-	cors := gorHandlers.CORS(gorHandlers.AllowedOrigins([]string{"http://localhost:3000"})) // "http://localhost:3000"   *
+	cors := gorHandlers.CORS(gorHandlers.AllowedOrigins(origins))
 
 	// new server- address, handler, tls, timeouts
 	s := &http.Server{
@@ -115,6 +167,26 @@ func main() {
 		}
 	}()
 
+	// gRPC server for the same ProductsDB, with reflection like the currency service
+	gs := grpc.NewServer()
+	productpb.RegisterProductServiceServer(gs, server.NewProducts(l.Named("grpc"), v, pdb))
+	reflection.Register(gs)
+
+	// start gRPC server listen as a non-blocking separate go routine
+	go func() {
+		listener, err := net.Listen("tcp", *grpcBindAddress)
+		if err != nil {
+			l.Error("Unable to listen", "error", err)
+			os.Exit(1)
+		}
+		l.Info("Started gRPC server", "bind_address", *grpcBindAddress)
+		err = gs.Serve(listener)
+		if err != nil {
+			l.Error("error starting gRPC server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
 	// graceful shutdown with os signal -> set signal notification on our sig channel
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -128,6 +200,7 @@ func main() {
 	// context and its parent alive longer than necessary.
 	defer cancel()
 	s.Shutdown(tc)
+	gs.GracefulStop()
 }
 
 // R1 - LCX
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/product-api/data"
+	"github.com/satoshi-u/go-microservices/product-api/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ProductsDB is the part of data.ProductsDB used by the server
+type ProductsDB interface {
+	GetProducts(currency string) (data.Products, error)
+	GetProductByID(id int, currency string) (*data.Product, error)
+	AddProduct(p *data.Product) *data.Product
+	UpdateProduct(p *data.Product) (*data.Product, error)
+	DeleteProduct(id int) (*data.Product, error)
+	ConvertProduct(p *data.Product, currency string) (*data.Product, error)
+	Watch() (<-chan data.Change, func())
+}
+
+// Products implements ProductServiceServer over the same ProductsDB as the REST handlers
+type Products struct {
+	log hclog.Logger
+	v   *data.Validation
+	pdb ProductsDB
+	*pb.UnimplementedProductServiceServer
+}
+
+// NewProducts - gives back a products server
+func NewProducts(l hclog.Logger, v *data.Validation, pdb ProductsDB) *Products {
+	return &Products{l, v, pdb, &pb.UnimplementedProductServiceServer{}}
+}
+
+// ListProducts returns all products priced in the requested currency
+func (p *Products) ListProducts(ctx context.Context, r *pb.ListProductsRequest) (*pb.ListProductsResponse, error) {
+	p.log.Debug("Handle ListProducts", "currency", r.GetCurrency())
+
+	prods, err := p.pdb.GetProducts(r.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.ListProductsResponse{Products: toProtos(prods)}, nil
+}
+
+// GetProduct returns a single product priced in the requested currency
+func (p *Products) GetProduct(ctx context.Context, r *pb.GetProductRequest) (*pb.Product, error) {
+	p.log.Debug("Handle GetProduct", "id", r.GetID(), "currency", r.GetCurrency())
+
+	prod, err := p.pdb.GetProductByID(int(r.GetID()), r.GetCurrency())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(prod), nil
+}
+
+// CreateProduct validates and adds a new product
+func (p *Products) CreateProduct(ctx context.Context, r *pb.CreateProductRequest) (*pb.Product, error) {
+	p.log.Debug("Handle CreateProduct", "product", r.GetProduct())
+
+	prod, err := p.validate(r.GetProduct())
+	if err != nil {
+		return nil, err
+	}
+	return toProto(p.pdb.AddProduct(prod)), nil
+}
+
+// UpdateProduct validates and replaces an existing product
+func (p *Products) UpdateProduct(ctx context.Context, r *pb.UpdateProductRequest) (*pb.Product, error) {
+	p.log.Debug("Handle UpdateProduct", "product", r.GetProduct())
+
+	prod, err := p.validate(r.GetProduct())
+	if err != nil {
+		return nil, err
+	}
+	prod, err = p.pdb.UpdateProduct(prod)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(prod), nil
+}
+
+// DeleteProduct removes a product and returns it
+func (p *Products) DeleteProduct(ctx context.Context, r *pb.DeleteProductRequest) (*pb.Product, error) {
+	p.log.Debug("Handle DeleteProduct", "id", r.GetID())
+
+	prod, err := p.pdb.DeleteProduct(int(r.GetID()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return toProto(prod), nil
+}
+
+// WatchProducts streams every product change and rate update for the
+// requested currency until the client goes away
+func (p *Products) WatchProducts(r *pb.WatchProductsRequest, src pb.ProductService_WatchProductsServer) error {
+	p.log.Debug("Handle WatchProducts", "currency", r.GetCurrency())
+	// rate updates are for upper case codes
+	cur := strings.ToUpper(r.GetCurrency())
+
+	changes, stop := p.pdb.Watch()
+	defer stop()
+
+	// fetch the prices once up front, this also subscribes ProductsDB to rate
+	// updates for the currency and fails early for unknown currencies
+	_, err := p.pdb.GetProducts(cur)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	for {
+		select {
+		case <-src.Context().Done():
+			return nil
+		case c := <-changes:
+			e := &pb.ProductEvent{Type: c.Type}
+			switch c.Type {
+			case data.ChangeRateUpdated:
+				if c.Currency != cur {
+					continue
+				}
+				prods, err := p.pdb.GetProducts(cur)
+				if err != nil {
+					p.log.Error("Unable to get products for watcher", "currency", cur, "error", err)
+					continue
+				}
+				e.Products = toProtos(prods)
+			case data.ChangeProductDeleted:
+				// the product is gone from ProductsDB, convert the one of the change
+				prod, err := p.pdb.ConvertProduct(c.Product, cur)
+				if err != nil {
+					p.log.Error("Unable to convert deleted product for watcher", "id", c.Product.ID, "error", err)
+					continue
+				}
+				e.Product = toProto(prod)
+			default:
+				prod, err := p.pdb.GetProductByID(c.Product.ID, cur)
+				if err != nil {
+					p.log.Error("Unable to get product for watcher", "id", c.Product.ID, "error", err)
+					continue
+				}
+				e.Product = toProto(prod)
+			}
+
+			err := src.Send(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// validate converts the product and checks it with the same rules as the REST API
+func (p *Products) validate(pp *pb.Product) (*data.Product, error) {
+	if pp == nil {
+		return nil, status.Error(codes.InvalidArgument, "product is required")
+	}
+	prod := fromProto(pp)
+	errs := p.v.Validate(prod)
+	if errs != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid product: %s", strings.Join(errs.Errors(), ", "))
+	}
+	return prod, nil
+}
+
+// toStatus maps ProductsDB errors to gRPC status errors
+func toStatus(err error) error {
+	if err == data.ErrProductNotFound {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, data.ErrInvalidCurrency) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func toProto(p *data.Product) *pb.Product {
+	return &pb.Product{
+		ID:          int64(p.ID),
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		SKU:         p.SKU,
+	}
+}
+
+func toProtos(ps data.Products) []*pb.Product {
+	pps := []*pb.Product{}
+	for _, p := range ps {
+		pps = append(pps, toProto(p))
+	}
+	return pps
+}
+
+func fromProto(pp *pb.Product) *data.Product {
+	return &data.Product{
+		ID:          int(pp.GetID()),
+		Name:        pp.GetName(),
+		Description: pp.GetDescription(),
+		Price:       pp.GetPrice(),
+		SKU:         pp.GetSKU(),
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/product-api/data"
+	"github.com/satoshi-u/go-microservices/product-api/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubDB is a ProductsDB with a single product priced in EUR and INR
+type stubDB struct {
+	changes chan data.Change
+	// err is returned when getting products, instead of the prices
+	err error
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+func newStubDB() *stubDB {
+	return &stubDB{changes: make(chan data.Change, 1)}
+}
+
+func (s *stubDB) price(currency string) (float64, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	switch currency {
+	case "", "EUR":
+		return 2.45, nil
+	case "INR":
+		return 245, nil
+	}
+	return 0, fmt.Errorf("%w %q", data.ErrInvalidCurrency, currency)
+}
+
+func (s *stubDB) GetProducts(currency string) (data.Products, error) {
+	p, err := s.GetProductByID(1, currency)
+	if err != nil {
+		return nil, err
+	}
+	return data.Products{p}, nil
+}
+
+func (s *stubDB) GetProductByID(id int, currency string) (*data.Product, error) {
+	if id != 1 {
+		return nil, data.ErrProductNotFound
+	}
+	price, err := s.price(currency)
+	if err != nil {
+		return nil, err
+	}
+	return &data.Product{ID: 1, Name: "Latte", Price: price, SKU: "prod-bev-001"}, nil
+}
+
+func (s *stubDB) AddProduct(p *data.Product) *data.Product {
+	p.ID = 2
+	return p
+}
+
+func (s *stubDB) UpdateProduct(p *data.Product) (*data.Product, error) {
+	if p.ID != 1 {
+		return nil, data.ErrProductNotFound
+	}
+	return p, nil
+}
+
+func (s *stubDB) DeleteProduct(id int) (*data.Product, error) {
+	return s.GetProductByID(id, "")
+}
+
+func (s *stubDB) ConvertProduct(p *data.Product, currency string) (*data.Product, error) {
+	price, err := s.price(currency)
+	if err != nil {
+		return nil, err
+	}
+	np := *p
+	np.Price = np.Price * price / 2.45
+	return &np, nil
+}
+
+func (s *stubDB) Watch() (<-chan data.Change, func()) {
+	return s.changes, func() {
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+	}
+}
+
+func (s *stubDB) watching() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.stopped
+}
+
+func setupServer(db *stubDB) *Products {
+	return NewProducts(hclog.NewNullLogger(), data.NewValidation(), db)
+}
+
+func TestProductsStatusCodes(t *testing.T) {
+	db := newStubDB()
+	s := setupServer(db)
+	ctx := context.Background()
+
+	p, err := s.GetProduct(ctx, &pb.GetProductRequest{ID: 1, Currency: "INR"})
+	assert.NoError(t, err)
+	assert.Equal(t, 245.0, p.GetPrice())
+
+	_, err = s.GetProduct(ctx, &pb.GetProductRequest{ID: 9})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.DeleteProduct(ctx, &pb.DeleteProductRequest{ID: 9})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = s.UpdateProduct(ctx, &pb.UpdateProductRequest{Product: &pb.Product{ID: 9, Name: "Tea", Price: 2.5, SKU: "prod-bev-005"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.ListProducts(ctx, &pb.ListProductsRequest{Currency: "XXX"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.CreateProduct(ctx, &pb.CreateProductRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.CreateProduct(ctx, &pb.CreateProductRequest{Product: &pb.Product{Name: "Tea", Price: 2.5, SKU: "bad"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	p, err = s.CreateProduct(ctx, &pb.CreateProductRequest{Product: &pb.Product{Name: "Tea", Price: 2.5, SKU: "prod-bev-005"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), p.GetID())
+
+	// anything else is an internal error
+	db.err = fmt.Errorf("currency service is down")
+	_, err = s.ListProducts(ctx, &pb.ListProductsRequest{Currency: "INR"})
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+// watchStream is the server side of a WatchProducts stream
+type watchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *pb.ProductEvent
+}
+
+func (w *watchStream) Context() context.Context { return w.ctx }
+
+func (w *watchStream) Send(e *pb.ProductEvent) error {
+	w.sent <- e
+	return nil
+}
+
+func TestWatchProducts(t *testing.T) {
+	db := newStubDB()
+	s := setupServer(db)
+
+	err := s.WatchProducts(&pb.WatchProductsRequest{Currency: "XXX"}, &watchStream{ctx: context.Background()})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	db = newStubDB()
+	s = setupServer(db)
+	ctx, cancel := context.WithCancel(context.Background())
+	ws := &watchStream{ctx: ctx, sent: make(chan *pb.ProductEvent, 1)}
+	done := make(chan error)
+	go func() {
+		done <- s.WatchProducts(&pb.WatchProductsRequest{Currency: "inr"}, ws)
+	}()
+
+	// rates of other currencies are skipped
+	db.changes <- data.Change{Type: data.ChangeRateUpdated, Currency: "USD", Rate: 1.1}
+	db.changes <- data.Change{Type: data.ChangeRateUpdated, Currency: "INR", Rate: 100}
+	e := <-ws.sent
+	assert.Equal(t, data.ChangeRateUpdated, e.GetType())
+	assert.Len(t, e.GetProducts(), 1)
+
+	db.changes <- data.Change{Type: data.ChangeProductUpdated, Product: &data.Product{ID: 1}}
+	e = <-ws.sent
+	assert.Equal(t, 245.0, e.GetProduct().GetPrice())
+
+	// deleted products are priced in the watched currency like the others
+	db.changes <- data.Change{Type: data.ChangeProductDeleted, Product: &data.Product{ID: 3, Price: 4.9}}
+	e = <-ws.sent
+	assert.Equal(t, int64(3), e.GetProduct().GetID())
+	assert.InDelta(t, 490.0, e.GetProduct().GetPrice(), 1e-9)
+
+	// the watch ends without an error when the client goes away
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("WatchProducts did not return after the client went away")
+	}
+	assert.False(t, db.watching())
+}
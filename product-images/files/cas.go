@@ -0,0 +1,360 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// ContentAddressed is a Storage which saves every file as a blob named by the SHA-256
+// digest of its contents in another Storage, so identical images uploaded for several
+// products are stored once. An index maps the paths of the files to their digest,
+// blobs no longer referenced by the index are removed by GC.
+//
+// The other Storage holds:
+//
+//	index.json            the index of paths to digests
+//	blobs/{aa}/{digest}   the blobs, aa being the first two characters of the digest
+//
+// The index is kept in memory and saved whole on every change, so only one instance
+// may use the other Storage. A change of index.json by another instance is detected
+// before the next save, which fails with ErrIndexChanged.
+type ContentAddressed struct {
+	store Storage
+
+	// mu guards index, indexInfo and pending
+	mu    sync.Mutex
+	index map[string]indexEntry
+	// indexInfo is index.json as last read or saved, to detect other writers
+	indexInfo FileInfo
+	// pending counts the saves of each digest in progress, their blobs are not referenced yet
+	pending map[string]int
+}
+
+// indexEntry is a file in the index
+type indexEntry struct {
+	Digest  string    `json:"digest"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+}
+
+const (
+	indexPath = "index.json"
+	blobsDir  = "blobs/"
+)
+
+// importable matches the paths of the images saved before the storage was content
+// addressed, {id}/{filename} like the routes of the handlers
+var importable = regexp.MustCompile(`^[0-9]+/[a-zA-Z]+\.[a-z]{3,4}$`)
+
+// unlimitedSaver is implemented by storages which limit the size of saved files,
+// the index is saved without the limit as it grows with the number of images
+type unlimitedSaver interface {
+	saveUnlimited(path string, contents io.Reader) error
+}
+
+// ErrIndexChanged is returned when index.json was saved by another instance
+var ErrIndexChanged = xerrors.New("index was changed by another instance, only one instance may use the storage")
+
+// NewContentAddressed creates a ContentAddressed storage keeping its blobs and index in store
+func NewContentAddressed(store Storage) (*ContentAddressed, error) {
+	c := &ContentAddressed{store: store, index: map[string]indexEntry{}, pending: map[string]int{}}
+
+	fi, err := store.Stat(indexPath)
+	if xerrors.Is(err, ErrNotFound) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read index: %w", err)
+	}
+	c.indexInfo = fi
+	rc, err := store.Get(indexPath)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to read index: %w", err)
+	}
+	defer rc.Close()
+
+	err = json.NewDecoder(rc).Decode(&c.index)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to decode index: %w", err)
+	}
+	return c, nil
+}
+
+// blobPath returns the path of the blob with the given digest
+func blobPath(digest string) string {
+	return blobsDir + digest[:2] + "/" + digest
+}
+
+// Save the contents of the Reader to the given path, the blob is only written
+// when no other file has the same contents
+func (c *ContentAddressed) Save(path string, contents io.Reader) error {
+	d, err := io.ReadAll(contents)
+	if err != nil {
+		return xerrors.Errorf("Unable to read file: %w", err)
+	}
+	sum := sha256.Sum256(d)
+	digest := hex.EncodeToString(sum[:])
+
+	// GC must not remove the blob before it is in the index
+	c.mu.Lock()
+	c.pending[digest]++
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.pending[digest]--
+		if c.pending[digest] == 0 {
+			delete(c.pending, digest)
+		}
+	}()
+
+	_, err = c.store.Stat(blobPath(digest))
+	if xerrors.Is(err, ErrNotFound) {
+		err = c.store.Save(blobPath(digest), bytes.NewReader(d))
+	}
+	if err != nil {
+		return xerrors.Errorf("Unable to save blob: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.updateIndex(cleanPath(path), &indexEntry{Digest: digest, Size: int64(len(d)), ModTime: time.Now().UTC()})
+}
+
+// updateIndex sets the entry of path, or removes it when e is nil, and saves the index.
+// The change is undone when the index cannot be saved. mu must be held.
+func (c *ContentAddressed) updateIndex(path string, e *indexEntry) error {
+	prev, existed := c.index[path]
+	if e == nil {
+		delete(c.index, path)
+	} else {
+		c.index[path] = *e
+	}
+
+	err := c.checkIndex()
+	if err == nil {
+		var d []byte
+		d, err = json.Marshal(c.index)
+		if err == nil {
+			err = c.saveIndex(d)
+		}
+	}
+	if err != nil {
+		if existed {
+			c.index[path] = prev
+		} else {
+			delete(c.index, path)
+		}
+		return xerrors.Errorf("Unable to save index: %w", err)
+	}
+
+	// the next save fails if this is not the last index saved
+	c.indexInfo, err = c.store.Stat(indexPath)
+	if err != nil {
+		return xerrors.Errorf("Unable to check saved index: %w", err)
+	}
+	return nil
+}
+
+// saveIndex writes d as index.json
+func (c *ContentAddressed) saveIndex(d []byte) error {
+	if us, ok := c.store.(unlimitedSaver); ok {
+		return us.saveUnlimited(indexPath, bytes.NewReader(d))
+	}
+	return c.store.Save(indexPath, bytes.NewReader(d))
+}
+
+// checkIndex returns ErrIndexChanged when index.json is not the one last read
+// or saved by this instance. mu must be held.
+func (c *ContentAddressed) checkIndex() error {
+	fi, err := c.store.Stat(indexPath)
+	if xerrors.Is(err, ErrNotFound) {
+		fi, err = FileInfo{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Size != c.indexInfo.Size || !fi.ModTime.Equal(c.indexInfo.ModTime) {
+		return ErrIndexChanged
+	}
+	return nil
+}
+
+// entry returns the index entry of path
+func (c *ContentAddressed) entry(path string) (indexEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.index[cleanPath(path)]
+	return e, ok
+}
+
+// Get the file at the given path and return a Reader
+// the calling function is responsible for closing the reader
+func (c *ContentAddressed) Get(path string) (io.ReadCloser, error) {
+	e, ok := c.entry(path)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c.store.Get(blobPath(e.Digest))
+}
+
+// Open returns a Reader for the blob of the file at the given path and the details
+// of the same file, blobs never change so the digest describes the contents exactly
+func (c *ContentAddressed) Open(path string) (io.ReadCloser, FileInfo, error) {
+	// GC holds mu while it removes blobs, so the blob is opened before it can go
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := cleanPath(path)
+	e, ok := c.index[p]
+	if !ok {
+		return nil, FileInfo{}, ErrNotFound
+	}
+	rc, err := c.store.Get(blobPath(e.Digest))
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	return rc, e.info(p), nil
+}
+
+// Delete removes the file at the given path from the index, its blob is
+// removed by GC unless other files have the same contents
+func (c *ContentAddressed) Delete(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := cleanPath(path)
+	if _, ok := c.index[p]; !ok {
+		return ErrNotFound
+	}
+	return c.updateIndex(p, nil)
+}
+
+// Stat returns the details of the file at the given path, including its digest
+func (c *ContentAddressed) Stat(path string) (FileInfo, error) {
+	p := cleanPath(path)
+	e, ok := c.entry(p)
+	if !ok {
+		return FileInfo{}, ErrNotFound
+	}
+	return e.info(p), nil
+}
+
+// List returns the files whose path starts with prefix
+func (c *ContentAddressed) List(prefix string) ([]FileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix = cleanPrefix(prefix)
+	fis := []FileInfo{}
+	for p, e := range c.index {
+		if strings.HasPrefix(p, prefix) {
+			fis = append(fis, e.info(p))
+		}
+	}
+	sortFiles(fis)
+	return fis, nil
+}
+
+// Import adds the files saved directly in the other Storage, as {id}/{filename} before
+// it was content addressed, to the index with their modified time and removes them.
+// Files already in the index, left over by an interrupted import, are only removed.
+// Any other file, such as the index, the blobs or files of other applications sharing
+// the Storage, is left alone. It returns how many files were imported.
+func (c *ContentAddressed) Import() (int, error) {
+	fis, err := c.store.List("")
+	if err != nil {
+		return 0, xerrors.Errorf("Unable to list files: %w", err)
+	}
+
+	imported := 0
+	for _, fi := range fis {
+		if !importable.MatchString(fi.Path) {
+			continue
+		}
+		if _, ok := c.entry(fi.Path); !ok {
+			err = c.importFile(fi)
+			if err != nil {
+				return imported, xerrors.Errorf("Unable to import %s: %w", fi.Path, err)
+			}
+			imported++
+		}
+		err = c.store.Delete(fi.Path)
+		if err != nil && !xerrors.Is(err, ErrNotFound) {
+			return imported, xerrors.Errorf("Unable to remove imported %s: %w", fi.Path, err)
+		}
+	}
+	return imported, nil
+}
+
+// importFile saves the blob of a file of the other Storage and adds it to the index
+func (c *ContentAddressed) importFile(fi FileInfo) error {
+	rc, err := c.store.Get(fi.Path)
+	if err != nil {
+		return err
+	}
+	d, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(d)
+	digest := hex.EncodeToString(sum[:])
+
+	// GC holds mu while it runs, so the blob can not be removed before it is indexed
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.store.Stat(blobPath(digest))
+	if xerrors.Is(err, ErrNotFound) {
+		err = c.store.Save(blobPath(digest), bytes.NewReader(d))
+	}
+	if err != nil {
+		return xerrors.Errorf("Unable to save blob: %w", err)
+	}
+	return c.updateIndex(fi.Path, &indexEntry{Digest: digest, Size: int64(len(d)), ModTime: fi.ModTime.UTC()})
+}
+
+func (e indexEntry) info(path string) FileInfo {
+	return FileInfo{Path: path, Size: e.Size, ContentType: contentType(path), ModTime: e.ModTime, Digest: e.Digest}
+}
+
+// GC removes the blobs which are not referenced by any file and returns how many were removed
+func (c *ContentAddressed) GC() (int, error) {
+	blobs, err := c.store.List(blobsDir)
+	if err != nil {
+		return 0, xerrors.Errorf("Unable to list blobs: %w", err)
+	}
+
+	// hold the lock while deleting so a save of the same contents waits for the
+	// blob to be gone and writes it again
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	referenced := map[string]bool{}
+	for _, e := range c.index {
+		referenced[e.Digest] = true
+	}
+
+	removed := 0
+	for _, b := range blobs {
+		digest := b.Path[strings.LastIndex(b.Path, "/")+1:]
+		if referenced[digest] || c.pending[digest] > 0 {
+			continue
+		}
+		err := c.store.Delete(b.Path)
+		if err != nil && !xerrors.Is(err, ErrNotFound) {
+			return removed, xerrors.Errorf("Unable to delete blob: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,201 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func digestOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestContentAddressedStoresIdenticalFilesOnce(t *testing.T) {
+	m := NewMemory()
+	c, err := NewContentAddressed(m)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Save("1/hansa.png", bytes.NewBufferString("Hello World")))
+	assert.NoError(t, c.Save("2/hansa.png", bytes.NewBufferString("Hello World")))
+	assert.NoError(t, c.Save("2/other.png", bytes.NewBufferString("Other")))
+
+	blobs, err := m.List("blobs/")
+	assert.NoError(t, err)
+	assert.Len(t, blobs, 2)
+
+	r, err := c.Get("/2/hansa.png")
+	assert.NoError(t, err)
+	d, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, "Hello World", string(d))
+
+	fi, err := c.Stat("1/hansa.png")
+	assert.NoError(t, err)
+	assert.Equal(t, digestOf("Hello World"), fi.Digest)
+	assert.Equal(t, int64(11), fi.Size)
+	assert.Equal(t, "image/png", fi.ContentType)
+
+	fis, err := c.List("2/")
+	assert.NoError(t, err)
+	assert.Len(t, fis, 2)
+	assert.Equal(t, "2/hansa.png", fis[0].Path)
+	assert.Equal(t, "2/other.png", fis[1].Path)
+
+	_, err = c.Get("3/hansa.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = c.Stat("3/hansa.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestContentAddressedGCRemovesUnreferencedBlobs(t *testing.T) {
+	m := NewMemory()
+	c, err := NewContentAddressed(m)
+	assert.NoError(t, err)
+
+	assert.NoError(t, c.Save("1/hansa.png", bytes.NewBufferString("Hello World")))
+	assert.NoError(t, c.Save("2/hansa.png", bytes.NewBufferString("Hello World")))
+	// replacing a file leaves its old blob unreferenced
+	assert.NoError(t, c.Save("3/hansa.png", bytes.NewBufferString("Old")))
+	assert.NoError(t, c.Save("3/hansa.png", bytes.NewBufferString("New")))
+
+	// the blob is still used by 2/hansa.png
+	assert.NoError(t, c.Delete("1/hansa.png"))
+	assert.ErrorIs(t, c.Delete("1/hansa.png"), ErrNotFound)
+
+	n, err := c.GC()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = m.Stat(blobPath(digestOf("Old")))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	r, err := c.Get("2/hansa.png")
+	assert.NoError(t, err)
+	r.Close()
+
+	assert.NoError(t, c.Delete("2/hansa.png"))
+	n, err = c.GC()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	blobs, err := m.List("blobs/")
+	assert.NoError(t, err)
+	assert.Len(t, blobs, 1)
+	assert.Equal(t, blobPath(digestOf("New")), blobs[0].Path)
+}
+
+func TestContentAddressedLoadsIndex(t *testing.T) {
+	m := NewMemory()
+	c, err := NewContentAddressed(m)
+	assert.NoError(t, err)
+	assert.NoError(t, c.Save("1/hansa.png", bytes.NewBufferString("Hello World")))
+
+	// a new instance over the same storage sees the files of the previous one
+	c, err = NewContentAddressed(m)
+	assert.NoError(t, err)
+	fi, err := c.Stat("1/hansa.png")
+	assert.NoError(t, err)
+	assert.Equal(t, digestOf("Hello World"), fi.Digest)
+
+	n, err := c.GC()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestContentAddressedImportsExistingFiles(t *testing.T) {
+	m := NewMemory()
+	// saved before the storage was content addressed
+	assert.NoError(t, m.Save("1/hansa.png", bytes.NewBufferString("Hello World")))
+	assert.NoError(t, m.Save("2/hansa.png", bytes.NewBufferString("Hello World")))
+	before, _ := m.Stat("2/hansa.png")
+	// not images of a product, they are left alone
+	assert.NoError(t, m.Save("backup/1/hansa.png", bytes.NewBufferString("Hello World")))
+	assert.NoError(t, m.Save("1/notes", bytes.NewBufferString("Hello World")))
+
+	c, err := NewContentAddressed(m)
+	assert.NoError(t, err)
+	n, err := c.Import()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	r, err := c.Get("1/hansa.png")
+	assert.NoError(t, err)
+	d, _ := io.ReadAll(r)
+	assert.Equal(t, "Hello World", string(d))
+	fi, err := c.Stat("2/hansa.png")
+	assert.NoError(t, err)
+	assert.True(t, before.ModTime.Equal(fi.ModTime))
+
+	// the raw files are gone, both paths share a single blob
+	fis, _ := m.List("")
+	paths := []string{}
+	for _, fi := range fis {
+		paths = append(paths, fi.Path)
+	}
+	assert.Equal(t, []string{"1/notes", "backup/1/hansa.png", blobPath(digestOf("Hello World")), indexPath}, paths)
+
+	n, err = c.Import()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestContentAddressedDetectsOtherInstances(t *testing.T) {
+	m := NewMemory()
+	a, err := NewContentAddressed(m)
+	assert.NoError(t, err)
+	b, err := NewContentAddressed(m)
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Save("1/hansa.png", bytes.NewBufferString("Hello World")))
+	assert.NoError(t, a.Save("2/hansa.png", bytes.NewBufferString("Hello World")))
+
+	// b would overwrite the files saved by a
+	err = b.Save("3/hansa.png", bytes.NewBufferString("Hello World"))
+	assert.ErrorIs(t, err, ErrIndexChanged)
+	_, err = b.Stat("3/hansa.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	fis, err := a.List("")
+	assert.NoError(t, err)
+	assert.Len(t, fis, 2)
+}
+
+func TestContentAddressedSavesLargeIndexOnLocal(t *testing.T) {
+	l, err := NewLocal(t.TempDir(), 16)
+	assert.NoError(t, err)
+	c, err := NewContentAddressed(l)
+	assert.NoError(t, err)
+
+	// the index of a single image is larger than the max file size of the uploads
+	assert.NoError(t, c.Save("1/hansa.png", bytes.NewBufferString("Hello World")))
+	assert.ErrorIs(t, c.Save("2/hansa.png", bytes.NewBufferString("Hello World, again")), ErrFileTooLarge)
+
+	c, err = NewContentAddressed(l)
+	assert.NoError(t, err)
+	_, err = c.Stat("1/hansa.png")
+	assert.NoError(t, err)
+}
+
+func TestContentAddressedOpenDescribesTheOpenedFile(t *testing.T) {
+	c, err := NewContentAddressed(NewMemory())
+	assert.NoError(t, err)
+	assert.NoError(t, c.Save("1/hansa.png", bytes.NewBufferString("Hello World")))
+
+	rc, fi, err := Open(c, "1/hansa.png")
+	assert.NoError(t, err)
+	defer rc.Close()
+
+	// a new version saved while the old one is read does not change what was opened
+	assert.NoError(t, c.Save("1/hansa.png", bytes.NewBufferString("Hello Again")))
+	d, _ := io.ReadAll(rc)
+	assert.Equal(t, "Hello World", string(d))
+	assert.Equal(t, digestOf("Hello World"), fi.Digest)
+	assert.Equal(t, int64(len(d)), fi.Size)
+
+	_, _, err = Open(c, "2/hansa.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
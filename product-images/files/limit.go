@@ -0,0 +1,40 @@
+package files
+
+import (
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrFileTooLarge is returned when a file is larger than the maximum size allowed
+var ErrFileTooLarge = xerrors.New("file is too large")
+
+// maxSizeReader reads at most max bytes and fails with ErrFileTooLarge when there are more
+type maxSizeReader struct {
+	r io.Reader
+	n int64 // bytes left before the limit
+}
+
+// MaxSizeReader returns a Reader which reads from r and returns ErrFileTooLarge
+// when r has more than max bytes, rather than silently truncating like io.LimitReader
+func MaxSizeReader(r io.Reader, max int64) io.Reader {
+	return &maxSizeReader{r: r, n: max}
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	if m.n <= 0 {
+		// the limit is reached, the file is too large if there is anything left
+		var b [1]byte
+		n, err := m.r.Read(b[:])
+		if n > 0 {
+			return 0, ErrFileTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > m.n {
+		p = p[:m.n]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	return n, err
+}
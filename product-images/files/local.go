@@ -2,9 +2,9 @@ package files
 
 import (
 	"io"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/xerrors"
 )
@@ -12,25 +12,50 @@ import (
 // Local is an implementation of the Storage interface which works with the
 // local disk on the current machine
 type Local struct {
-	maxFileSize int // maximum numbber of bytes for files
+	maxFileSize int // maximum number of bytes for files
 	basePath    string
 }
 
 // NewLocal creates a new Local filesytem with the given base path
 // basePath is the base directory to save files to
 // maxSize is the max number of bytes that a file can be
+// Temporary files left behind by saves interrupted by a crash are removed.
 func NewLocal(basePath string, maxSize int) (*Local, error) {
 	p, err := filepath.Abs(basePath)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Local{basePath: p, maxFileSize: 1024 * 1024 * 10}, nil
+	l := &Local{basePath: p, maxFileSize: maxSize}
+	err = l.removeTempFiles()
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to remove temporary files: %w", err)
+	}
+	return l, nil
 }
 
+// tempPrefix starts the name of the temporary files written by Save, such files
+// are never returned by List
+const tempPrefix = ".tmp-"
+
 // Save the contents of the Writer to the given path
 // path is a relative path, basePath will be appended
+//
+// The contents are written to a temporary file in the same directory which is
+// synced and renamed over the file, so readers see the previous or the new file
+// and a failed or interrupted save never leaves a missing or truncated file.
 func (l *Local) Save(path string, contents io.Reader) error {
+	return l.save(path, MaxSizeReader(contents, int64(l.maxFileSize)))
+}
+
+// saveUnlimited saves without the max file size, for the files of the storage itself
+// such as the index of ContentAddressed which grows with the number of images
+func (l *Local) saveUnlimited(path string, contents io.Reader) error {
+	return l.save(path, contents)
+}
+
+// save writes the contents to the given path through a temporary file
+func (l *Local) save(path string, contents io.Reader) error {
 	// get the full path for the file
 	fp := l.fullPath(path)
 
@@ -41,44 +66,79 @@ func (l *Local) Save(path string, contents io.Reader) error {
 		return xerrors.Errorf("Unable to create directory: %w", err)
 	}
 
-	// if the file exists delete it
-	_, err = os.Stat(fp)
-	if err == nil {
-		err = os.Remove(fp)
-		if err != nil {
-			return xerrors.Errorf("Unable to delete file: %w", err)
-		}
-	} else if !os.IsNotExist(err) {
-		// if this is anything other than a not exists error
-		return xerrors.Errorf("Unable to get file info: %w", err)
-	}
-
-	// create a new file at the path
-	f, err := os.Create(fp)
+	// create a temporary file next to the file, a rename is only atomic within a file system
+	f, err := os.CreateTemp(d, tempPrefix+filepath.Base(fp)+"-*")
 	if err != nil {
 		return xerrors.Errorf("Unable to create file: %w", err)
 	}
-	defer f.Close()
+	tmp := f.Name()
 
-	// write the contents to the new file
-	// ensure that we are not writing greater than max bytes
-	written, err := io.Copy(f, contents)
-	log.Println("written", written)
+	// write the contents to the temporary file
+	_, err = io.Copy(f, contents)
+	if err == nil {
+		// make sure the contents are on disk before the file is replaced
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// CreateTemp creates files only readable by the owner
+		err = os.Chmod(tmp, 0644)
+	}
 	if err != nil {
+		os.Remove(tmp)
 		return xerrors.Errorf("Unable to write to file: %w", err)
 	}
 
+	err = os.Rename(tmp, fp)
+	if err != nil {
+		os.Remove(tmp)
+		return xerrors.Errorf("Unable to replace file: %w", err)
+	}
+
+	// sync the directory so the rename survives a crash, not every platform supports it
+	if df, err := os.Open(d); err == nil {
+		df.Sync()
+		df.Close()
+	}
 	return nil
 }
 
+// removeTempFiles removes the temporary files of interrupted saves under basePath
+func (l *Local) removeTempFiles() error {
+	err := filepath.Walk(l.basePath, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && isTempFile(fp) {
+			return os.Remove(fp)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		// nothing has been saved yet
+		return nil
+	}
+	return err
+}
+
+// isTempFile returns true for the temporary files written by Save
+func isTempFile(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), tempPrefix)
+}
+
 // Get the file at the given path and return a Reader
 // the calling function is responsible for closing the reader
-func (l *Local) Get(path string) (*os.File, error) {
+func (l *Local) Get(path string) (io.ReadCloser, error) {
 	// get the full path for the file
 	fp := l.fullPath(path)
 
 	// open the file
 	f, err := os.Open(fp)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, xerrors.Errorf("Unable to open file: %w", err)
 	}
@@ -86,6 +146,94 @@ func (l *Local) Get(path string) (*os.File, error) {
 	return f, nil
 }
 
+// Open the file at the given path and return a Reader with the details of the open file,
+// a file replaced afterwards does not change either
+func (l *Local) Open(path string) (io.ReadCloser, FileInfo, error) {
+	f, err := os.Open(l.fullPath(path))
+	if os.IsNotExist(err) {
+		return nil, FileInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, FileInfo{}, xerrors.Errorf("Unable to open file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		f.Close()
+		return nil, FileInfo{}, ErrNotFound
+	}
+	if err != nil {
+		f.Close()
+		return nil, FileInfo{}, xerrors.Errorf("Unable to get file info: %w", err)
+	}
+	return f, l.fileInfo(cleanPath(path), fi), nil
+}
+
+// Delete the file at the given path, directories left empty are kept
+func (l *Local) Delete(path string) error {
+	err := os.Remove(l.fullPath(path))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return xerrors.Errorf("Unable to delete file: %w", err)
+	}
+	return nil
+}
+
+// Stat returns the details of the file at the given path
+func (l *Local) Stat(path string) (FileInfo, error) {
+	fi, err := os.Stat(l.fullPath(path))
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return FileInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return FileInfo{}, xerrors.Errorf("Unable to get file info: %w", err)
+	}
+	return l.fileInfo(cleanPath(path), fi), nil
+}
+
+// List returns the files whose path relative to basePath starts with prefix
+func (l *Local) List(prefix string) ([]FileInfo, error) {
+	prefix = cleanPrefix(prefix)
+	fis := []FileInfo{}
+
+	// only walk the directory the prefix is in, e.g. 1 for 1/ha
+	root := l.basePath
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = l.fullPath(prefix[:i])
+	}
+
+	err := filepath.Walk(root, func(fp string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fp == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if fi.IsDir() || isTempFile(fp) {
+			return nil
+		}
+		rel, err := filepath.Rel(l.basePath, fp)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, prefix) {
+			fis = append(fis, l.fileInfo(rel, fi))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to list files: %w", err)
+	}
+	sortFiles(fis)
+	return fis, nil
+}
+
+func (l *Local) fileInfo(path string, fi os.FileInfo) FileInfo {
+	return FileInfo{Path: path, Size: fi.Size(), ContentType: contentType(path), ModTime: fi.ModTime()}
+}
+
 // returns the absolute path
 func (l *Local) fullPath(path string) string {
 	// append the given path to the base path
@@ -2,6 +2,7 @@ package files
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -11,11 +12,8 @@ import (
 )
 
 func setupLocal(t *testing.T) (*Local, string, func()) {
-	// create a temporary directory
-	dir, err := ioutil.TempDir("../teststore", "tmpfile")
-	if err != nil {
-		t.Fatal(err)
-	}
+	// create a temporary directory, removed when the test completes
+	dir := t.TempDir()
 
 	l, err := NewLocal(dir, 1024*1024*10)
 	if err != nil {
@@ -23,8 +21,7 @@ func setupLocal(t *testing.T) (*Local, string, func()) {
 	}
 
 	return l, dir, func() {
-		// cleanup function
-		// os.RemoveAll(dir)
+		// cleanup function, t.TempDir is removed by the testing package
 	}
 }
 
@@ -66,3 +63,111 @@ func TestGetsContentsAndWritesToWriter(t *testing.T) {
 	d, _ := ioutil.ReadAll(r)
 	assert.Equal(t, fileContents, string(d))
 }
+
+func TestListsStatsAndDeletesFiles(t *testing.T) {
+	l, _, cleanup := setupLocal(t)
+	defer cleanup()
+	for _, p := range []string{"/1/b.png", "/1/a.png", "/10/c.png"} {
+		assert.NoError(t, l.Save(p, bytes.NewBufferString("Hello World")))
+	}
+
+	// the prefix 1/ does not include the files of product 10
+	fis, err := l.List("1/")
+	assert.NoError(t, err)
+	assert.Len(t, fis, 2)
+	assert.Equal(t, "1/a.png", fis[0].Path)
+	assert.Equal(t, "image/png", fis[0].ContentType)
+	assert.Equal(t, int64(11), fis[0].Size)
+
+	fis, err = l.List("2/")
+	assert.NoError(t, err)
+	assert.Empty(t, fis)
+
+	fi, err := l.Stat("/10/c.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "10/c.png", fi.Path)
+
+	assert.NoError(t, l.Delete("/1/a.png"))
+	_, err = l.Stat("/1/a.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, l.Delete("/1/a.png"), ErrNotFound)
+}
+
+func TestSaveTooLargeFileLeavesNoFile(t *testing.T) {
+	dir := t.TempDir()
+	l, err := NewLocal(dir, 10)
+	assert.NoError(t, err)
+
+	err = l.Save("/1/hello.txt", bytes.NewBufferString("Hello World"))
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+
+	_, err = os.Stat(filepath.Join(dir, "1", "hello.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// errorReader returns some data and then fails, like a client disconnecting mid upload
+type errorReader struct {
+	data []byte
+	read bool
+}
+
+func (e *errorReader) Read(p []byte) (int, error) {
+	if !e.read {
+		e.read = true
+		return copy(p, e.data), nil
+	}
+	return 0, errors.New("connection reset")
+}
+
+func TestFailedSaveKeepsExistingFile(t *testing.T) {
+	savePath := "/1/hello.txt"
+	l, dir, cleanup := setupLocal(t)
+	defer cleanup()
+
+	err := l.Save(savePath, bytes.NewBufferString("Hello World"))
+	assert.NoError(t, err)
+
+	// the failed save is reported and the previous contents are intact
+	err = l.Save(savePath, &errorReader{data: []byte("Goodbye")})
+	assert.Error(t, err)
+
+	d, err := ioutil.ReadFile(filepath.Join(dir, savePath))
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World", string(d))
+
+	// no temporary file is left behind
+	entries, err := os.ReadDir(filepath.Join(dir, "1"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestFailedSaveOfNewFileLeavesNoFile(t *testing.T) {
+	l, dir, cleanup := setupLocal(t)
+	defer cleanup()
+
+	err := l.Save("/1/hello.txt", &errorReader{data: []byte("Hello")})
+	assert.Error(t, err)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "1"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestNewLocalRemovesTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "1"), os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1", "hello.txt"), []byte("Hello World"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1", tempPrefix+"hello.txt-123"), []byte("Hel"), 0644))
+
+	_, err := NewLocal(dir, 1024)
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "1"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "hello.txt", entries[0].Name())
+
+	// a base path which does not exist yet is not an error
+	_, err = NewLocal(filepath.Join(dir, "missing"), 1024)
+	assert.NoError(t, err)
+}
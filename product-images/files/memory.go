@@ -0,0 +1,121 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// Memory is an implementation of the Storage interface which keeps files in
+// memory, files are lost when the process exits so it is meant for tests
+type Memory struct {
+	mu    sync.RWMutex
+	files map[string]memoryFile
+}
+
+// memoryFile is the contents of a file and when it was saved
+type memoryFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemory creates a new empty Memory storage
+func NewMemory() *Memory {
+	return &Memory{files: map[string]memoryFile{}}
+}
+
+// Save the contents of the Reader to the given path, replacing any existing file
+func (m *Memory) Save(path string, contents io.Reader) error {
+	d, err := io.ReadAll(contents)
+	if err != nil {
+		return xerrors.Errorf("Unable to read file: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[cleanPath(path)] = memoryFile{data: d, modTime: time.Now()}
+	return nil
+}
+
+// Get the file at the given path and return a Reader
+func (m *Memory) Get(path string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, ok := m.files[cleanPath(path)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// files are replaced rather than modified so the slice can be shared with the reader
+	return readSeekNopCloser{bytes.NewReader(f.data)}, nil
+}
+
+// Open returns a Reader for the file at the given path and its details
+func (m *Memory) Open(path string) (io.ReadCloser, FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := cleanPath(path)
+	f, ok := m.files[p]
+	if !ok {
+		return nil, FileInfo{}, ErrNotFound
+	}
+	return readSeekNopCloser{bytes.NewReader(f.data)}, f.info(p), nil
+}
+
+// readSeekNopCloser lets the readers of Memory files seek, for range requests
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error { return nil }
+
+// Delete the file at the given path
+func (m *Memory) Delete(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := cleanPath(path)
+	if _, ok := m.files[p]; !ok {
+		return ErrNotFound
+	}
+	delete(m.files, p)
+	return nil
+}
+
+// Stat returns the details of the file at the given path
+func (m *Memory) Stat(path string) (FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := cleanPath(path)
+	f, ok := m.files[p]
+	if !ok {
+		return FileInfo{}, ErrNotFound
+	}
+	return f.info(p), nil
+}
+
+// List returns the files whose path starts with prefix
+func (m *Memory) List(prefix string) ([]FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prefix = cleanPrefix(prefix)
+	fis := []FileInfo{}
+	for p, f := range m.files {
+		if strings.HasPrefix(p, prefix) {
+			fis = append(fis, f.info(p))
+		}
+	}
+	sortFiles(fis)
+	return fis, nil
+}
+
+func (f memoryFile) info(path string) FileInfo {
+	return FileInfo{Path: path, Size: int64(len(f.data)), ContentType: contentType(path), ModTime: f.modTime}
+}
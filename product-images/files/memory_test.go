@@ -0,0 +1,58 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemorySavesAndGetsFiles(t *testing.T) {
+	m := NewMemory()
+
+	err := m.Save("/1/hello.txt", bytes.NewBufferString("Hello World"))
+	assert.NoError(t, err)
+
+	// paths with and without a leading slash are the same file
+	r, err := m.Get("1/hello.txt")
+	assert.NoError(t, err)
+	d, _ := io.ReadAll(r)
+	assert.Equal(t, "Hello World", string(d))
+
+	_, err = m.Get("/1/missing.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemoryStatListAndDelete(t *testing.T) {
+	m := NewMemory()
+	assert.NoError(t, m.Save("1/b.png", bytes.NewBufferString("png")))
+	assert.NoError(t, m.Save("1/a.jpg", bytes.NewBufferString("jpeg!")))
+	assert.NoError(t, m.Save("10/a.png", bytes.NewBufferString("other")))
+
+	fi, err := m.Stat("/1/a.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "1/a.jpg", fi.Path)
+	assert.Equal(t, int64(5), fi.Size)
+	assert.Equal(t, "image/jpeg", fi.ContentType)
+	assert.False(t, fi.ModTime.IsZero())
+
+	// sorted by path, the files of 10/ are not in 1/
+	fis, err := m.List("1/")
+	assert.NoError(t, err)
+	assert.Len(t, fis, 2)
+	assert.Equal(t, "1/a.jpg", fis[0].Path)
+	assert.Equal(t, "1/b.png", fis[1].Path)
+
+	fis, err = m.List("2/")
+	assert.NoError(t, err)
+	assert.NotNil(t, fis)
+	assert.Empty(t, fis)
+
+	assert.NoError(t, m.Delete("1/a.jpg"))
+	assert.ErrorIs(t, m.Delete("1/a.jpg"), ErrNotFound)
+	_, err = m.Stat("1/a.jpg")
+	assert.ErrorIs(t, err, ErrNotFound)
+	fis, _ = m.List("1/")
+	assert.Len(t, fis, 1)
+}
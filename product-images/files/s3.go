@@ -0,0 +1,331 @@
+package files
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// S3Config configures an S3 storage
+type S3Config struct {
+	// Endpoint is the URL of the object store, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000 for MinIO
+	Endpoint string
+	Region   string
+	Bucket   string
+	// AccessKey and SecretKey are used to sign the requests
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as endpoint/bucket/key rather than bucket.endpoint/key,
+	// MinIO and most other S3-compatible stores need it
+	PathStyle bool
+}
+
+// S3 is an implementation of the Storage interface which keeps files in an
+// S3-compatible object store, requests are signed with AWS Signature Version 4
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	// now returns the time requests are signed at, tests replace it
+	now func() time.Time
+}
+
+// NewS3 creates a new S3 storage, a nil client uses http.DefaultClient
+func NewS3(cfg S3Config, client *http.Client) (*S3, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, xerrors.Errorf("Invalid S3 endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, xerrors.Errorf("Invalid S3 endpoint %q, expected a URL such as http://localhost:9000", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, xerrors.New("S3 bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3{cfg: cfg, endpoint: u, client: client, now: time.Now}, nil
+}
+
+// Save the contents of the Reader as the object at the given path. The contents
+// are read into memory as the payload hash is part of the signature.
+func (s *S3) Save(path string, contents io.Reader) error {
+	d, err := io.ReadAll(contents)
+	if err != nil {
+		return xerrors.Errorf("Unable to read file: %w", err)
+	}
+
+	req, err := s.newRequest(http.MethodPut, path, d)
+	if err != nil {
+		return err
+	}
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	s.sign(req, d)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return xerrors.Errorf("Unable to upload file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+	return nil
+}
+
+// Get the object at the given path and return a Reader
+// the calling function is responsible for closing the reader
+func (s *S3) Get(path string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.objectURL(path))
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to download file: %w", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, s.responseError(resp)
+	}
+}
+
+// Delete the object at the given path. S3 does not report deleting a missing
+// object as an error so its existence is checked first.
+func (s *S3) Delete(path string) error {
+	_, err := s.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodDelete, s.objectURL(path))
+	if err != nil {
+		return xerrors.Errorf("Unable to delete file: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s.responseError(resp)
+	}
+	return nil
+}
+
+// Stat returns the details of the object at the given path from a HEAD request
+func (s *S3) Stat(path string) (FileInfo, error) {
+	resp, err := s.do(http.MethodHead, s.objectURL(path))
+	if err != nil {
+		return FileInfo{}, xerrors.Errorf("Unable to get file info: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return FileInfo{}, ErrNotFound
+	default:
+		return FileInfo{}, xerrors.Errorf("S3 request failed with status %d", resp.StatusCode)
+	}
+
+	fi := FileInfo{Path: cleanPath(path), Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if fi.ContentType == "" {
+		fi.ContentType = contentType(fi.Path)
+	}
+	fi.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return fi, nil
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the objects whose key starts with prefix, following continuation
+// tokens when there are more objects than fit in one response. The content type
+// is not part of the listing so it is derived from the file extension.
+func (s *S3) List(prefix string) ([]FileInfo, error) {
+	fis := []FileInfo{}
+	token := ""
+	for {
+		u := s.bucketURL()
+		q := url.Values{"list-type": {"2"}, "prefix": {cleanPrefix(prefix)}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(q)
+
+		resp, err := s.do(http.MethodGet, u)
+		if err != nil {
+			return nil, xerrors.Errorf("Unable to list files: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return nil, s.responseError(resp)
+		}
+
+		lbr := listBucketResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&lbr)
+		resp.Body.Close()
+		if err != nil {
+			return nil, xerrors.Errorf("Unable to decode S3 listing: %w", err)
+		}
+
+		for _, c := range lbr.Contents {
+			fis = append(fis, FileInfo{Path: c.Key, Size: c.Size, ContentType: contentType(c.Key), ModTime: c.LastModified})
+		}
+		if !lbr.IsTruncated || lbr.NextContinuationToken == "" {
+			break
+		}
+		token = lbr.NextContinuationToken
+	}
+	sortFiles(fis)
+	return fis, nil
+}
+
+// do sends a signed request without a body
+func (s *S3) do(method string, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create S3 request: %w", err)
+	}
+	s.sign(req, nil)
+	return s.client.Do(req)
+}
+
+// bucketURL returns the URL of the bucket
+func (s *S3) bucketURL() *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	}
+	return &u
+}
+
+// objectURL returns the URL of the object stored at path
+func (s *S3) objectURL(path string) *url.URL {
+	u := s.bucketURL()
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + cleanPath(path)
+	return u
+}
+
+func (s *S3) newRequest(method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, s.objectURL(path).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to create S3 request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	return req, nil
+}
+
+// responseError returns an error with the S3 error response body
+func (s *S3) responseError(resp *http.Response) error {
+	d, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return xerrors.Errorf("S3 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(d)))
+}
+
+// sign adds the AWS Signature Version 4 Authorization header to req, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3) sign(req *http.Request, payload []byte) {
+	t := s.now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	day := t.Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// canonical headers are the host and every x-amz-*, content-type and range header
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" || lk == "range" {
+			headers[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// canonicalQuery encodes the query parameters sorted by name, spaces as %20
+func canonicalQuery(q url.Values) string {
+	return strings.ReplaceAll(q.Encode(), "+", "%20")
+}
+
+func sha256Hex(d []byte) string {
+	h := sha256.Sum256(d)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,46 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"golang.org/x/xerrors"
+)
+
+// ErrUnsupportedType is returned when a file is not one of the allowed image types
+var ErrUnsupportedType = xerrors.New("unsupported file type, expected a PNG, JPEG, GIF or WebP image")
+
+// sniffLen is the number of bytes needed to recognise every allowed type
+const sniffLen = 12
+
+// DetectImageType returns the content type of an image from its first bytes, only
+// PNG, JPEG, GIF and WebP are recognised, anything else returns ErrUnsupportedType
+func DetectImageType(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png", nil
+	case bytes.HasPrefix(header, []byte("\xff\xd8\xff")):
+		return "image/jpeg", nil
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "image/gif", nil
+	case len(header) >= sniffLen && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return "image/webp", nil
+	}
+	return "", ErrUnsupportedType
+}
+
+// SniffImage detects the type of the image in r from its content, whatever the
+// client declared. The returned Reader still yields all of the contents of r.
+func SniffImage(r io.Reader) (string, io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return "", nil, err
+	}
+	ct, err := DetectImageType(header)
+	if err != nil {
+		return "", nil, err
+	}
+	return ct, br, nil
+}
@@ -0,0 +1,47 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectImageType(t *testing.T) {
+	tests := map[string]string{
+		"\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR": "image/png",
+		"\xff\xd8\xff\xe0\x00\x10JFIF":        "image/jpeg",
+		"GIF89a\x01\x00\x01\x00":              "image/gif",
+		"RIFF\x24\x00\x00\x00WEBPVP8 ":        "image/webp",
+	}
+	for header, ct := range tests {
+		got, err := DetectImageType([]byte(header))
+		assert.NoError(t, err)
+		assert.Equal(t, ct, got)
+	}
+
+	for _, header := range []string{"", "Hello World", "<svg xmlns=", "RIFF\x24\x00\x00\x00WAVE"} {
+		_, err := DetectImageType([]byte(header))
+		assert.ErrorIs(t, err, ErrUnsupportedType)
+	}
+}
+
+func TestSniffImageKeepsContents(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR and the rest"
+
+	ct, r, err := SniffImage(bytes.NewBufferString(png))
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", ct)
+	d, _ := io.ReadAll(r)
+	assert.Equal(t, png, string(d))
+}
+
+func TestMaxSizeReader(t *testing.T) {
+	d, err := io.ReadAll(MaxSizeReader(bytes.NewBufferString("Hello World"), 11))
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World", string(d))
+
+	_, err = io.ReadAll(MaxSizeReader(bytes.NewBufferString("Hello World"), 10))
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+}
@@ -1,9 +1,96 @@
 package files
 
-import "io"
+import (
+	"io"
+	"mime"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
 
 // Storage defines the behavior for file operations
 // Implementations may be of the type -> local disk, or cloud storage, etc
 type Storage interface {
 	Save(path string, file io.Reader) error
+	// Get returns the contents of the file at path, the caller must close it.
+	// ErrNotFound is returned when there is no such file.
+	Get(path string) (io.ReadCloser, error)
+	// Delete removes the file at path, ErrNotFound is returned when there is no such file
+	Delete(path string) error
+	// List returns the files whose path starts with prefix, sorted by path
+	List(prefix string) ([]FileInfo, error)
+	// Stat returns the details of the file at path, ErrNotFound is returned when there is no such file
+	Stat(path string) (FileInfo, error)
+}
+
+// Opener is implemented by storages which return the contents of a file together
+// with its details, both of the same version of the file
+type Opener interface {
+	// Open returns the contents and the details of the file at path, the caller must
+	// close the contents. ErrNotFound is returned when there is no such file.
+	Open(path string) (io.ReadCloser, FileInfo, error)
+}
+
+// Open returns the contents and the details of the file at path from s. Storages which
+// are not an Opener are asked twice, so a file replaced in between may be described by
+// the details of another version.
+func Open(s Storage, path string) (io.ReadCloser, FileInfo, error) {
+	if o, ok := s.(Opener); ok {
+		return o.Open(path)
+	}
+	fi, err := s.Stat(path)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	rc, err := s.Get(path)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	return rc, fi, nil
+}
+
+// FileInfo describes a file in the Storage
+type FileInfo struct {
+	// Path is relative to the root of the Storage, without a leading slash
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	ModTime     time.Time `json:"modified"`
+	// Digest is the hex SHA-256 of the contents, only set by storages which know it
+	Digest string `json:"digest,omitempty"`
+}
+
+// ErrNotFound is returned when a file does not exist in the Storage
+var ErrNotFound = xerrors.New("file not found")
+
+// contentType returns the content type for a file from its extension
+func contentType(path string) string {
+	if ct := mime.TypeByExtension(filepath.Ext(path)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// sortFiles sorts files by path
+func sortFiles(fis []FileInfo) {
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Path < fis[j].Path })
+}
+
+// cleanPath returns the key for a file, paths with and without a leading
+// slash refer to the same file like they do for Local
+func cleanPath(path string) string {
+	return filepath.ToSlash(filepath.Join("/", path))[1:]
+}
+
+// cleanPrefix is cleanPath for the prefix of a List, a trailing slash is kept
+// so the prefix 1/ does not match the files of 10/
+func cleanPrefix(prefix string) string {
+	p := cleanPath(prefix)
+	if p != "" && strings.HasSuffix(prefix, "/") {
+		p += "/"
+	}
+	return p
 }
@@ -7,6 +7,7 @@ require (
 	github.com/hashicorp/go-hclog v1.2.1
 	github.com/nicholasjackson/env v0.6.0
 	github.com/stretchr/testify v1.8.0
+	golang.org/x/image v0.18.0
 	golang.org/x/xerrors v0.0.0-20220609144429-65e65417b02f
 )
 
@@ -1,27 +1,52 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"path/filepath"
+	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-hclog"
 	"github.com/satoshi-u/go-microservices/product-images/files"
+	"github.com/satoshi-u/go-microservices/product-images/images"
 )
 
 // Files is a handler for reading and writing files
 type Files struct {
-	log   hclog.Logger
-	store files.Storage
+	log     hclog.Logger
+	store   files.Storage
+	maxSize int64 // maximum number of bytes for uploaded files
+	// maxAge is how long clients may cache images without revalidating them
+	maxAge time.Duration
+	// variants generates the named variants of uploaded images, may be nil
+	variants *images.Variants
+	// resizing holds a slot for every image being resized on request
+	resizing chan struct{}
 }
 
-// NewFiles creates a new File handler
-func NewFiles(s files.Storage, l hclog.Logger) *Files {
-	return &Files{store: s, log: l}
+// NewFiles creates a new File handler accepting files up to maxSize bytes
+func NewFiles(s files.Storage, maxSize int64, l hclog.Logger) *Files {
+	return &Files{store: s, maxSize: maxSize, log: l, resizing: make(chan struct{}, defaultResizeLimit)}
 }
 
+// WithVariants generates the named variants of every uploaded image with v
+func (f *Files) WithVariants(v *images.Variants) *Files {
+	f.variants = v
+	return f
+}
+
+// multipartOverhead is allowed on top of the max file size for the boundaries
+// and the other fields of a multipart form
+const multipartOverhead = 64 * 1024
+
+// filenamePattern matches the filenames accepted by the routes
+var filenamePattern = regexp.MustCompile(`^[a-zA-Z]+\.[a-z]{3,4}$`)
+
 // UploadREST implements the http.Handler interface
 func (f *Files) UploadREST(rw http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -40,32 +65,156 @@ func (f *Files) UploadREST(rw http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
+	if r.ContentLength > f.maxSize {
+		f.log.Error("File too large", "content_length", r.ContentLength)
+		http.Error(rw, "file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
 	f.saveFile(id, fn, rw, r.Body)
 	r.Body.Close()
 }
 
-// UploadMultipart
+// UploadMultipart saves the file of a multipart form with id and file fields
 func (f *Files) UploadMultipart(rw http.ResponseWriter, r *http.Request) {
+	limit := f.maxSize + multipartOverhead
+	if r.ContentLength > limit {
+		f.log.Error("File too large", "content_length", r.ContentLength)
+		http.Error(rw, "file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	// the content length may be unknown, so the body is limited as well
+	r.Body = readCloser{files.MaxSizeReader(r.Body, limit), r.Body}
+
 	err := r.ParseMultipartForm(128 * 1024)
+	if errors.Is(err, files.ErrFileTooLarge) {
+		f.log.Error("File too large", "error", err)
+		http.Error(rw, "file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		f.log.Error("Bad Request", "error", err)
 		http.Error(rw, "expected multipart form data", http.StatusBadRequest)
+		return
 	}
+	// parts larger than the max memory are stored in temporary files
+	defer r.MultipartForm.RemoveAll()
 
 	id, err := strconv.Atoi(r.FormValue("id"))
 	f.log.Info("Process form for id", "id", id)
 	if err != nil {
 		f.log.Error("Bad Request", "error", err)
 		http.Error(rw, "expected integer id", http.StatusBadRequest)
+		return
 	}
 
 	mf, mfh, err := r.FormFile("file")
 	if err != nil {
 		f.log.Error("Bad Request", "error", err)
 		http.Error(rw, "expected file", http.StatusBadRequest)
+		return
+	}
+	defer mf.Close()
+
+	// the filename comes from the client, unlike with UploadREST it is not checked by the router
+	if !filenamePattern.MatchString(mfh.Filename) {
+		f.log.Error("Bad Request", "filename", mfh.Filename)
+		http.Error(rw, "invalid filename, expected a name such as hansa.png", http.StatusBadRequest)
+		return
+	}
+
+	f.saveFile(strconv.Itoa(id), mfh.Filename, rw, mf)
+}
+
+// readCloser reads from a Reader and closes a Closer, to replace a request body with a wrapped one
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ServeFile returns the file for a product from the Storage, with support for
+// conditional and range requests
+func (f *Files) ServeFile(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	fn := vars["filename"]
+
+	f.log.Debug("Handle GET", "id", id, "filename", fn)
+
+	if images.HasOptions(r.URL.Query()) {
+		f.serveResized(rw, r, id, fn)
+		return
+	}
+
+	path := filepath.Join(id, fn)
+	rc, fi, err := files.Open(f.store, path)
+	if errors.Is(err, files.ErrNotFound) {
+		http.Error(rw, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		f.log.Error("Unable to get file", "error", err)
+		http.Error(rw, "Unable to get file", http.StatusInternalServerError)
+		return
 	}
+	defer rc.Close()
 
-	f.saveFile(r.FormValue("id"), mfh.Filename, rw, mf)
+	// the type of the contents, not of the extension they were uploaded with
+	f.serveContent(rw, r, fi, "", rc)
+}
+
+// DeleteFile removes a file of a product from the Storage
+func (f *Files) DeleteFile(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	fn := vars["filename"]
+
+	f.log.Info("Handle DELETE", "id", id, "filename", fn)
+
+	err := f.store.Delete(filepath.Join(id, fn))
+	if errors.Is(err, files.ErrNotFound) {
+		http.Error(rw, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		f.log.Error("Unable to delete file", "error", err)
+		http.Error(rw, "Unable to delete file", http.StatusInternalServerError)
+		return
+	}
+	f.removeResized(id, fn)
+	if f.variants != nil {
+		f.variants.Remove(id, fn)
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// ListFiles returns the files of a product as JSON, with their size, content type and
+// modified time. A product without images has an empty list.
+func (f *Files) ListFiles(rw http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	f.log.Debug("Handle GET list", "id", id)
+
+	fis, err := f.store.List(id + "/")
+	if err != nil {
+		f.log.Error("Unable to list files", "error", err)
+		http.Error(rw, "Unable to list files", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(rw).Encode(fis)
+	if err != nil {
+		f.log.Error("Unable to encode files", "error", err)
+	}
+}
+
+// setETag sets a strong ETag from the digest of the file, when the Storage knows it.
+// Files with the same contents have the same ETag, whichever product they belong to.
+func setETag(rw http.ResponseWriter, fi files.FileInfo) {
+	if fi.Digest != "" {
+		rw.Header().Set("ETag", `"`+fi.Digest+`"`)
+	}
 }
 
 // func (f *Files) InvalidURI(uri string, rw http.ResponseWriter) {
@@ -73,13 +222,34 @@ func (f *Files) UploadMultipart(rw http.ResponseWriter, r *http.Request) {
 // 	http.Error(rw, "Invalid file path should be in the format: /[id]/[filepath]", http.StatusBadRequest)
 // }
 
-// saveFile saves the contents of the request to a file
-func (f *Files) saveFile(id, path string, rw http.ResponseWriter, r io.ReadCloser) {
-	f.log.Info("Save file for product", "id", id, "path", path)
+// saveFile saves the contents of the request to a file, the contents must be an
+// allowed image type, whatever the declared Content-Type, of at most maxSize bytes
+func (f *Files) saveFile(id, path string, rw http.ResponseWriter, r io.Reader) {
+	ct, r, err := files.SniffImage(files.MaxSizeReader(r, f.maxSize))
+	if err == nil {
+		f.log.Info("Save file for product", "id", id, "path", path, "content_type", ct)
+		err = f.store.Save(filepath.Join(id, path), r)
+	}
+	if err == nil {
+		if fi, err := f.store.Stat(filepath.Join(id, path)); err == nil {
+			setETag(rw, fi)
+		}
+		// resized images of the previous file are out of date
+		f.removeResized(id, path)
+		if f.variants != nil {
+			f.variants.Generate(id, path)
+		}
+	}
 
-	fp := filepath.Join(id, path)
-	err := f.store.Save(fp, r)
-	if err != nil {
+	switch {
+	case err == nil:
+	case errors.Is(err, files.ErrUnsupportedType):
+		f.log.Error("Unsupported file type", "id", id, "path", path)
+		http.Error(rw, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, files.ErrFileTooLarge):
+		f.log.Error("File too large", "id", id, "path", path, "max_size", f.maxSize)
+		http.Error(rw, "file is too large", http.StatusRequestEntityTooLarge)
+	default:
 		f.log.Error("Unable to save file", "error", err)
 		http.Error(rw, "Unable to save file", http.StatusInternalServerError)
 	}
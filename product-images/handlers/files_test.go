@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/product-images/files"
+	"github.com/satoshi-u/go-microservices/product-images/images"
+	"github.com/stretchr/testify/assert"
+)
+
+// pngHeader is enough of a PNG for the content type to be detected
+const pngHeader = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+func setupFiles(t *testing.T, maxSize int64) (*mux.Router, *files.Memory) {
+	store := files.NewMemory()
+	fh := NewFiles(store, maxSize, hclog.NewNullLogger())
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.UploadREST).Methods(http.MethodPost)
+	sm.HandleFunc("/", fh.UploadMultipart).Methods(http.MethodPost)
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.ServeFile).Methods(http.MethodGet)
+	sm.HandleFunc("/images/{id:[0-9]+}", fh.ListFiles).Methods(http.MethodGet)
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.DeleteFile).Methods(http.MethodDelete)
+	return sm, store
+}
+
+func TestUploadRESTChecksContent(t *testing.T) {
+	sm, store := setupFiles(t, 64)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"png.png", pngHeader + "data", http.StatusOK},
+		{"text.png", "Hello World, not an image", http.StatusUnsupportedMediaType},
+		{"large.png", pngHeader + strings.Repeat("x", 64), http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/images/1/"+tc.name, strings.NewReader(tc.body))
+		// the declared type is ignored
+		r.Header.Set("Content-Type", "image/png")
+		sm.ServeHTTP(rr, r)
+		assert.Equal(t, tc.code, rr.Code, tc.name)
+	}
+
+	fis, err := store.List("1/")
+	assert.NoError(t, err)
+	assert.Len(t, fis, 1)
+	assert.Equal(t, "1/png.png", fis[0].Path)
+}
+
+func TestUploadRESTLargeFileWithoutContentLength(t *testing.T) {
+	sm, store := setupFiles(t, 64)
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/images/1/large.png", strings.NewReader(pngHeader+strings.Repeat("x", 64)))
+	r.ContentLength = -1
+	sm.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	_, err := store.Stat("1/large.png")
+	assert.ErrorIs(t, err, files.ErrNotFound)
+}
+
+// multipartRequest creates a multipart upload of a file for a product
+func multipartRequest(t *testing.T, id, filename, contents string) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("id", id)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(contents))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestUploadMultipart(t *testing.T) {
+	sm, store := setupFiles(t, 64)
+
+	tests := []struct {
+		id       string
+		filename string
+		body     string
+		code     int
+	}{
+		{"1", "png.png", pngHeader, http.StatusOK},
+		{"abc", "png.png", pngHeader, http.StatusBadRequest},
+		{"1", "hansa1.png", pngHeader, http.StatusBadRequest},
+		{"1", "text.png", "Hello World", http.StatusUnsupportedMediaType},
+		{"1", "large.png", pngHeader + strings.Repeat("x", 64), http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range tests {
+		rr := httptest.NewRecorder()
+		sm.ServeHTTP(rr, multipartRequest(t, tc.id, tc.filename, tc.body))
+		assert.Equal(t, tc.code, rr.Code, tc.filename)
+	}
+
+	fis, err := store.List("")
+	assert.NoError(t, err)
+	assert.Len(t, fis, 1)
+}
+
+// testPNG returns a w x h png image
+func testPNG(t *testing.T, w, h int) []byte {
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, w, h)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestServeResizedImage(t *testing.T) {
+	sm, store := setupFiles(t, 1024*1024)
+
+	upload := func(w, h int) {
+		rr := httptest.NewRecorder()
+		sm.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/images/1/hansa.png", bytes.NewReader(testPNG(t, w, h))))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/1/hansa.png?"+query, nil))
+		return rr
+	}
+	size := func(rr *httptest.ResponseRecorder) []int {
+		cfg, _, err := image.DecodeConfig(rr.Body)
+		assert.NoError(t, err)
+		return []int{cfg.Width, cfg.Height}
+	}
+
+	upload(400, 200)
+	rr := get("w=100&format=jpeg")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+	assert.Equal(t, []int{100, 50}, size(rr))
+
+	// the resized image is cached and served from the cache
+	_, err := store.Stat("resized/1/hansa.png/100x0-q85.jpeg")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{100, 50}, size(get("w=100&format=jpeg")))
+
+	// uploading the image again invalidates the cache
+	upload(200, 200)
+	_, err = store.Stat("resized/1/hansa.png/100x0-q85.jpeg")
+	assert.ErrorIs(t, err, files.ErrNotFound)
+	assert.Equal(t, []int{100, 100}, size(get("w=100&format=jpeg")))
+
+	assert.Equal(t, http.StatusBadRequest, get("w=huge").Code)
+
+	rr = httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/2/hansa.png?w=100", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestServeVariant(t *testing.T) {
+	store := files.NewMemory()
+	vs, _ := images.ParseVariants("thumb=50x50-cover")
+	vg := images.NewVariants(hclog.NewNullLogger(), store, vs, 1, 10)
+	fh := NewFiles(store, 1024*1024, hclog.NewNullLogger()).WithVariants(vg)
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.UploadREST).Methods(http.MethodPost)
+	sm.HandleFunc("/images/{id:[0-9]+}/{variant:[a-z]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.ServeVariant).Methods(http.MethodGet)
+	sm.HandleFunc("/variants/status", fh.VariantStatus).Methods(http.MethodGet)
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/images/1/hansa.png", bytes.NewReader(testPNG(t, 200, 100))))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// the workers have not started, the variant is pending
+	rr = get("/images/1/thumb/hansa.png")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Contains(t, get("/variants/status").Body.String(), `"variant":"thumb"`)
+
+	vg.Start()
+	vg.Stop()
+
+	rr = get("/images/1/thumb/hansa.png")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	cfg, _, err := image.DecodeConfig(rr.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{50, 50}, []int{cfg.Width, cfg.Height})
+
+	assert.Equal(t, http.StatusNotFound, get("/images/1/huge/hansa.png").Code)
+	assert.Equal(t, http.StatusNotFound, get("/images/2/thumb/hansa.png").Code)
+	assert.JSONEq(t, `{"pending":[],"failed":[]}`, get("/variants/status").Body.String())
+}
+
+func TestServeFileETag(t *testing.T) {
+	store, err := files.NewContentAddressed(files.NewMemory())
+	assert.NoError(t, err)
+	fh := NewFiles(store, 1024*1024, hclog.NewNullLogger())
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.UploadREST).Methods(http.MethodPost)
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.ServeFile).Methods(http.MethodGet)
+
+	img := testPNG(t, 20, 10)
+	sum := sha256.Sum256(img)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	// the same image uploaded for two products has the same ETag
+	for _, id := range []string{"1", "2"} {
+		rr := httptest.NewRecorder()
+		sm.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/images/"+id+"/hansa.png", bytes.NewReader(img)))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+
+		rr = httptest.NewRecorder()
+		sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/"+id+"/hansa.png", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, etag, rr.Header().Get("ETag"))
+		assert.Equal(t, img, rr.Body.Bytes())
+	}
+}
+
+func TestServeFileConditionalAndRange(t *testing.T) {
+	store, err := files.NewContentAddressed(files.NewMemory())
+	assert.NoError(t, err)
+	fh := NewFiles(store, 1024*1024, hclog.NewNullLogger()).WithMaxAge(time.Hour)
+	mw := GzipHandler{}
+
+	sm := mux.NewRouter()
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.UploadREST).Methods(http.MethodPost)
+	getR := sm.Methods(http.MethodGet, http.MethodHead).Subrouter()
+	getR.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.ServeFile)
+	getR.Use(mw.GzipMiddleware)
+
+	img := testPNG(t, 20, 10)
+	rr := httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/images/1/hansa.png", bytes.NewReader(img)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	get := func(method string, h map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/images/1/hansa.png", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		for k, v := range h {
+			r.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		sm.ServeHTTP(rr, r)
+		return rr
+	}
+
+	// png is already compressed, so it is sent as is with its length
+	rr = get(http.MethodGet, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, strconv.Itoa(len(img)), rr.Header().Get("Content-Length"))
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=3600", rr.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, rr.Header().Get("Last-Modified"))
+	assert.Equal(t, "bytes", rr.Header().Get("Accept-Ranges"))
+	assert.Equal(t, img, rr.Body.Bytes())
+	etag := rr.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	rr = get(http.MethodGet, map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Empty(t, rr.Body.Bytes())
+
+	rr = get(http.MethodGet, map[string]string{"If-None-Match": `"other"`})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = get(http.MethodGet, map[string]string{"Range": "bytes=0-9"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "bytes 0-9/"+strconv.Itoa(len(img)), rr.Header().Get("Content-Range"))
+	assert.Equal(t, img[:10], rr.Body.Bytes())
+
+	rr = get(http.MethodHead, nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, strconv.Itoa(len(img)), rr.Header().Get("Content-Length"))
+	assert.Empty(t, rr.Body.Bytes())
+}
+
+func TestDeleteFile(t *testing.T) {
+	sm, store := setupFiles(t, 64)
+	assert.NoError(t, store.Save("1/png.png", strings.NewReader(pngHeader)))
+
+	rr := httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/images/1/png.png", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	_, err := store.Stat("1/png.png")
+	assert.ErrorIs(t, err, files.ErrNotFound)
+
+	// deleting again, or a file which never existed, is not found
+	for _, url := range []string{"/images/1/png.png", "/images/2/missing.png"} {
+		rr = httptest.NewRecorder()
+		sm.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, url, nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code, url)
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	sm, store := setupFiles(t, 64)
+	assert.NoError(t, store.Save("1/png.png", strings.NewReader(pngHeader)))
+	assert.NoError(t, store.Save("10/png.png", strings.NewReader(pngHeader)))
+
+	rr := httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/1", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	fis := []files.FileInfo{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fis))
+	assert.Len(t, fis, 1)
+	assert.Equal(t, "1/png.png", fis[0].Path)
+
+	// a product without images has an empty list rather than null
+	rr = httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/2", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, "[]", rr.Body.String())
+}
+
+func TestResizeCacheAndConcurrencyLimits(t *testing.T) {
+	store := files.NewMemory()
+	fh := NewFiles(store, 1024*1024, hclog.NewNullLogger()).WithResizeLimit(1)
+	sm := mux.NewRouter()
+	sm.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", fh.ServeFile).Methods(http.MethodGet)
+	assert.NoError(t, store.Save("1/hansa.png", bytes.NewReader(testPNG(t, 40, 40))))
+
+	// every size is served, but only maxResizedPerFile of them are cached
+	for w := 1; w <= maxResizedPerFile+2; w++ {
+		rr := httptest.NewRecorder()
+		sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/1/hansa.png?w="+strconv.Itoa(w), nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	cached, err := store.List(resizedPrefix("1", "hansa.png"))
+	assert.NoError(t, err)
+	assert.Len(t, cached, maxResizedPerFile)
+
+	// with the only slot taken, a resize waits until the client gives up
+	fh.resizing <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	rr := httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/1/hansa.png?w=39", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	// cached sizes do not need a slot
+	rr = httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/1/hansa.png?w=1", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	<-fh.resizing
+}
+
+func TestServeFileTypeFromContents(t *testing.T) {
+	sm, store := setupFiles(t, 1024*1024)
+
+	// a PNG uploaded with the extension of a JPEG is sent as the PNG it is
+	img := testPNG(t, 20, 10)
+	rr := httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/images/1/hansa.jpg", bytes.NewReader(img)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/1/hansa.jpg", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, img, rr.Body.Bytes())
+
+	// files which are not images, saved before uploads were checked, are never rendered
+	assert.NoError(t, store.Save("1/page.png", strings.NewReader("<html><script>alert(1)</script></html>")))
+	rr = httptest.NewRecorder()
+	sm.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/images/1/page.png", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/octet-stream", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"compress/gzip"
+	"mime"
 	"net/http"
 	"strings"
 )
@@ -10,15 +11,16 @@ import (
 type GzipHandler struct {
 }
 
-// GzipMiddleware is a middleware used in GetRouter that comes into play when req header has gzip as Accept-Encoding
+// GzipMiddleware is a middleware used in GetRouter that comes into play when req header has gzip as Accept-Encoding.
+// Range requests are never compressed, as the ranges are of the uncompressed file, and neither are
+// responses whose content type is already compressed such as jpeg or png images.
 func (g *GzipHandler) GzipMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		// check if gzip/--compressed
-		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			// create a gziped response
-			// rw.Write([]byte("works"))
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && r.Header.Get("Range") == "" {
+			// the response depends on the Accept-Encoding of the request
+			rw.Header().Add("Vary", "Accept-Encoding")
 			wrw := NewWrappedResponseWriter(rw)
-			wrw.Header().Set("Content-Encoding", "gzip")
 			next.ServeHTTP(wrw, r)
 			defer wrw.Flush()
 			return
@@ -28,16 +30,29 @@ func (g *GzipHandler) GzipMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Our Wrapped ResponseWriter which has gzip.Writer embedded to do gzip things
+// compressedTypes are content types which gzip does not make smaller
+var compressedTypes = map[string]bool{
+	"image/jpeg":       true,
+	"image/png":        true,
+	"image/gif":        true,
+	"image/webp":       true,
+	"application/zip":  true,
+	"application/gzip": true,
+}
+
+// Our Wrapped ResponseWriter which has gzip.Writer embedded to do gzip things.
+// Whether the response is compressed is decided from its headers when they are written.
 type WrappedResponseWriter struct {
 	rw http.ResponseWriter
 	gw *gzip.Writer
+	// wroteHeader is set once the headers are written, gw is only set if the response is compressed
+	wroteHeader bool
 }
 
-// NewWrappedResponseWriter returns a WrappedResponseWriter instance with given ResponseWriter, and adds gzip instance as well
+// NewWrappedResponseWriter returns a WrappedResponseWriter instance with given ResponseWriter,
+// the gzip writer is only created if the response is compressed
 func NewWrappedResponseWriter(rw http.ResponseWriter) *WrappedResponseWriter {
-	gw := gzip.NewWriter(rw)
-	return &WrappedResponseWriter{rw: rw, gw: gw}
+	return &WrappedResponseWriter{rw: rw}
 }
 
 // Header - same as http.ResponseWriter
@@ -45,18 +60,60 @@ func (wr *WrappedResponseWriter) Header() http.Header {
 	return wr.rw.Header()
 }
 
-// Write - overridden to use gzip write
+// Write - overridden to use gzip write when the response is compressed
 func (wr *WrappedResponseWriter) Write(d []byte) (int, error) {
+	if !wr.wroteHeader {
+		// like http.ResponseWriter the content type is detected from the first write
+		if wr.Header().Get("Content-Type") == "" {
+			wr.Header().Set("Content-Type", http.DetectContentType(d))
+		}
+		wr.WriteHeader(http.StatusOK)
+	}
+	if wr.gw == nil {
+		return wr.rw.Write(d)
+	}
 	return wr.gw.Write(d)
 }
 
-// WriteHeader - same as http.ResponseWriter
+// WriteHeader - compresses the response unless it is empty, partial or already compressed
 func (wr *WrappedResponseWriter) WriteHeader(statusCode int) {
+	if wr.wroteHeader {
+		return
+	}
+	wr.wroteHeader = true
+
+	if wr.compress(statusCode) {
+		h := wr.Header()
+		h.Set("Content-Encoding", "gzip")
+		// the length is of the uncompressed response
+		h.Del("Content-Length")
+		// the compressed bytes differ from the file so a strong ETag would be wrong
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		wr.gw = gzip.NewWriter(wr.rw)
+	}
 	wr.rw.WriteHeader(statusCode)
 }
 
+// compress returns true when a response with the given status should be compressed
+func (wr *WrappedResponseWriter) compress(statusCode int) bool {
+	h := wr.Header()
+	if statusCode < http.StatusOK || statusCode == http.StatusNoContent || statusCode == http.StatusNotModified ||
+		statusCode == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err != nil || !compressedTypes[mt]
+}
+
 // Flush - cleanup
 func (wr *WrappedResponseWriter) Flush() {
-	wr.gw.Flush()
-	wr.gw.Close()
+	if wr.gw != nil {
+		wr.gw.Flush()
+		wr.gw.Close()
+	}
 }
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipGet(t *testing.T, contentType, body string, h map[string]string) *httptest.ResponseRecorder {
+	mw := GzipHandler{}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", contentType)
+		rw.Header().Set("ETag", `"abc"`)
+		io.WriteString(rw, body)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	for k, v := range h {
+		r.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	mw.GzipMiddleware(next).ServeHTTP(rr, r)
+	return rr
+}
+
+func TestGzipMiddlewareCompressesText(t *testing.T) {
+	body := strings.Repeat(`{"path":"1/hansa.png"}`, 10)
+	rr := gzipGet(t, "application/json", body, nil)
+
+	assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", rr.Header().Get("Vary"))
+	// the compressed bytes are not the file the ETag is for
+	assert.Equal(t, `W/"abc"`, rr.Header().Get("ETag"))
+
+	gr, err := gzip.NewReader(rr.Body)
+	assert.NoError(t, err)
+	d, err := io.ReadAll(gr)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(d))
+}
+
+func TestGzipMiddlewareSkipsImagesAndRanges(t *testing.T) {
+	rr := gzipGet(t, "image/png", pngHeader, nil)
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, `"abc"`, rr.Header().Get("ETag"))
+	assert.Equal(t, pngHeader, rr.Body.String())
+
+	rr = gzipGet(t, "application/json", "{}", map[string]string{"Range": "bytes=0-1"})
+	assert.Empty(t, rr.Header().Get("Content-Encoding"))
+	assert.Equal(t, "{}", rr.Body.String())
+}
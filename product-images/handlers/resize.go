@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"github.com/satoshi-u/go-microservices/product-images/files"
+	"github.com/satoshi-u/go-microservices/product-images/images"
+)
+
+// resizedDir is the directory of the Storage resized images are cached in,
+// as resized/{id}/{filename}/{options} so they are not listed with the originals
+const resizedDir = "resized"
+
+const (
+	// defaultResizeLimit is the number of images resized on request at the same time
+	defaultResizeLimit = 4
+	// maxResizedPerFile is the number of resized images cached per file, other
+	// sizes are still served but resized on every request
+	maxResizedPerFile = 16
+)
+
+// WithResizeLimit sets the number of images resized on request at the same time,
+// requests for other resized images wait for one of them to finish
+func (f *Files) WithResizeLimit(n int) *Files {
+	f.resizing = make(chan struct{}, n)
+	return f
+}
+
+// resizedPrefix returns the prefix of the cached resized images of a file
+func resizedPrefix(id, fn string) string {
+	return filepath.ToSlash(filepath.Join(resizedDir, id, fn)) + "/"
+}
+
+// serveResized returns the file resized and converted as described by the query, e.g.
+// ?w=200&h=200&fit=cover&format=jpeg&q=80. Resized images are cached in the Storage.
+func (f *Files) serveResized(rw http.ResponseWriter, r *http.Request, id, fn string) {
+	o, err := images.ParseOptions(r.URL.Query())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := o.OutputFormat(images.SourceFormat(fn))
+	cp := resizedPrefix(id, fn) + o.Key(format)
+
+	rc, fi, err := files.Open(f.store, cp)
+	if err == nil {
+		defer rc.Close()
+		f.log.Debug("Serving cached resized image", "path", cp)
+		f.serveContent(rw, r, fi, "image/"+format, rc)
+		return
+	}
+	if !errors.Is(err, files.ErrNotFound) {
+		// the cache is only an optimisation, resize the original instead
+		f.log.Error("Unable to get cached resized image", "path", cp, "error", err)
+	}
+
+	// resizing is CPU and memory heavy, only a few images are resized at a time
+	select {
+	case f.resizing <- struct{}{}:
+		defer func() { <-f.resizing }()
+	case <-r.Context().Done():
+		http.Error(rw, "Timed out waiting to resize image", http.StatusServiceUnavailable)
+		return
+	}
+
+	d, fi, err := f.resize(filepath.Join(id, fn), resizedPrefix(id, fn), cp, o, format)
+	if errors.Is(err, files.ErrNotFound) {
+		http.Error(rw, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		f.log.Error("Unable to resize image", "id", id, "filename", fn, "error", err)
+		http.Error(rw, "Unable to resize image", http.StatusInternalServerError)
+		return
+	}
+	f.serveContent(rw, r, fi, "image/"+format, io.NopCloser(bytes.NewReader(d)))
+}
+
+// resize resizes the original file and caches the result at cp, unless the file already
+// has maxResizedPerFile images cached under prefix. The FileInfo of the result has the
+// modified time of the original.
+func (f *Files) resize(path, prefix, cp string, o images.Options, format string) ([]byte, files.FileInfo, error) {
+	before, err := f.store.Stat(path)
+	if err != nil {
+		return nil, files.FileInfo{}, err
+	}
+	rc, err := f.store.Get(path)
+	if err != nil {
+		return nil, files.FileInfo{}, err
+	}
+	defer rc.Close()
+
+	d, err := images.Resize(rc, o, format)
+	if err != nil {
+		return nil, files.FileInfo{}, err
+	}
+	fi := files.FileInfo{Path: cp, Size: int64(len(d)), ModTime: before.ModTime, Digest: digest(d)}
+
+	// the original may have been replaced while it was resized, in which case the
+	// result is served but not cached as the upload has already invalidated the cache
+	after, err := f.store.Stat(path)
+	if err != nil || !after.ModTime.Equal(before.ModTime) || after.Size != before.Size {
+		return d, fi, nil
+	}
+	// the sizes are chosen by the clients, the cache must not grow without bounds
+	cached, err := f.store.List(prefix)
+	if err != nil || len(cached) >= maxResizedPerFile {
+		f.log.Debug("Not caching resized image", "path", cp, "cached", len(cached), "error", err)
+		return d, fi, nil
+	}
+	err = f.store.Save(cp, bytes.NewReader(d))
+	if err != nil {
+		f.log.Error("Unable to cache resized image", "path", cp, "error", err)
+	}
+	return d, fi, nil
+}
+
+// removeResized deletes the cached resized images of a file, after it is replaced or deleted
+func (f *Files) removeResized(id, fn string) {
+	fis, err := f.store.List(resizedPrefix(id, fn))
+	if err != nil {
+		f.log.Error("Unable to list resized images", "id", id, "filename", fn, "error", err)
+		return
+	}
+	for _, fi := range fis {
+		err := f.store.Delete(fi.Path)
+		if err != nil && !errors.Is(err, files.ErrNotFound) {
+			f.log.Error("Unable to delete resized image", "path", fi.Path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/satoshi-u/go-microservices/product-images/files"
+)
+
+// WithMaxAge lets clients and proxies cache images for d before revalidating them
+// with their ETag or modified time, by default they revalidate on every use
+func (f *Files) WithMaxAge(d time.Duration) *Files {
+	f.maxAge = d
+	return f
+}
+
+// serveContent writes a file with http.ServeContent, which answers conditional requests
+// with 304 Not Modified and range requests with 206 Partial Content. The headers are
+// taken from fi, the content type is sniffed from the contents when contentType is empty.
+func (f *Files) serveContent(rw http.ResponseWriter, r *http.Request, fi files.FileInfo, contentType string, rc io.ReadCloser) {
+	rs, ok := rc.(io.ReadSeeker)
+	if !ok {
+		// ranges need to seek, files are at most maxSize bytes so they fit in memory
+		d, err := io.ReadAll(rc)
+		if err != nil {
+			f.log.Error("Unable to read file", "path", fi.Path, "error", err)
+			http.Error(rw, "Unable to get file", http.StatusInternalServerError)
+			return
+		}
+		rs = bytes.NewReader(d)
+	}
+
+	if contentType == "" {
+		var err error
+		contentType, err = sniffType(rs)
+		if err != nil {
+			f.log.Error("Unable to read file", "path", fi.Path, "error", err)
+			http.Error(rw, "Unable to get file", http.StatusInternalServerError)
+			return
+		}
+	}
+	rw.Header().Set("Content-Type", contentType)
+	// browsers must not guess another type, such as HTML, from the contents
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	setETag(rw, fi)
+	if f.maxAge > 0 {
+		rw.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(f.maxAge.Seconds())))
+	} else {
+		rw.Header().Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(rw, r, fi.Path, fi.ModTime, rs)
+}
+
+// sniffType returns the image type of the contents of rs and rewinds it. Contents which
+// are not an allowed image, such as files saved before uploads were checked, are sent
+// as application/octet-stream so browsers never render them.
+func sniffType(rs io.ReadSeeker) (string, error) {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(rs, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	_, err = rs.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+	ct, err := files.DetectImageType(header[:n])
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+	return ct, nil
+}
+
+// digest returns the hex SHA-256 of d, for the ETag of images which are not in the Storage
+func digest(d []byte) string {
+	sum := sha256.Sum256(d)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/satoshi-u/go-microservices/product-images/files"
+	"github.com/satoshi-u/go-microservices/product-images/images"
+)
+
+// ServeVariant returns a named variant of a product's image, such as a thumb,
+// generated in the background after the image was uploaded
+func (f *Files) ServeVariant(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	variant := vars["variant"]
+	fn := vars["filename"]
+
+	f.log.Debug("Handle GET variant", "id", id, "variant", variant, "filename", fn)
+
+	if f.variants == nil || !f.variants.Has(variant) {
+		http.Error(rw, "Unknown variant", http.StatusNotFound)
+		return
+	}
+
+	path := images.VariantPath(id, variant, fn)
+	rc, fi, err := files.Open(f.store, path)
+	if errors.Is(err, files.ErrNotFound) {
+		if f.variants.Pending(id, variant, fn) {
+			// the client can try again once the variant has been generated
+			rw.Header().Set("Retry-After", "1")
+			http.Error(rw, "Variant is being generated", http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(rw, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		f.log.Error("Unable to get variant", "error", err)
+		http.Error(rw, "Unable to get file", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	f.serveContent(rw, r, fi, "image/"+f.variants.Format(variant, fn), rc)
+}
+
+// VariantStatus returns the variant jobs which are pending or failed as JSON
+func (f *Files) VariantStatus(rw http.ResponseWriter, r *http.Request) {
+	s := images.VariantStatus{Pending: []images.Job{}, Failed: []images.Job{}}
+	if f.variants != nil {
+		s = f.variants.Status()
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(rw).Encode(s)
+	if err != nil {
+		f.log.Error("Unable to encode variant status", "error", err)
+	}
+}
@@ -0,0 +1,244 @@
+// Package images resizes and converts product images
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/xerrors"
+
+	// register the WebP decoder, WebP images can be converted but not produced
+	_ "golang.org/x/image/webp"
+)
+
+// Fit modes for images resized to both a width and a height
+const (
+	// FitContain scales the image to fit inside the box, keeping its aspect ratio
+	FitContain = "contain"
+	// FitCover scales the image to cover the box, keeping its aspect ratio, and crops the overflow
+	FitCover = "cover"
+	// FitFill stretches the image to the box
+	FitFill = "fill"
+)
+
+// Limits for the requested images
+const (
+	MaxDimension   = 4000
+	DefaultQuality = 85
+	// maxPixels stops images with huge dimensions from using all the memory when decoded
+	maxPixels = 50 * 1000 * 1000
+)
+
+// ErrInvalidOptions is returned for query parameters which are not valid Options
+var ErrInvalidOptions = xerrors.New("invalid image options")
+
+// Options describe a variant of an image
+type Options struct {
+	// Width and Height of the variant in pixels, 0 keeps the aspect ratio from the other one
+	Width  int
+	Height int
+	// Fit is FitContain, FitCover or FitFill, it only matters when both Width and Height are set
+	Fit string
+	// Format is jpeg, png or gif, empty keeps the format of the original
+	Format string
+	// Quality of jpeg images from 1 to 100
+	Quality int
+}
+
+// HasOptions returns true when the query asks for a variant rather than the original image
+func HasOptions(q url.Values) bool {
+	for _, k := range []string{"w", "h", "fit", "format", "q"} {
+		if _, ok := q[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOptions parses ?w=200&h=200&fit=cover&format=jpeg&q=80, every parameter is optional
+func ParseOptions(q url.Values) (Options, error) {
+	o := Options{Fit: FitContain, Quality: DefaultQuality}
+
+	var err error
+	if o.Width, err = dimension(q, "w"); err != nil {
+		return o, err
+	}
+	if o.Height, err = dimension(q, "h"); err != nil {
+		return o, err
+	}
+
+	if v := q.Get("fit"); v != "" {
+		switch v {
+		case FitContain, FitCover, FitFill:
+			o.Fit = v
+		default:
+			return o, xerrors.Errorf("%w: fit must be contain, cover or fill, got %q", ErrInvalidOptions, v)
+		}
+	}
+
+	if v := strings.ToLower(q.Get("format")); v != "" {
+		switch v {
+		case "jpeg", "jpg":
+			o.Format = "jpeg"
+		case "png", "gif":
+			o.Format = v
+		default:
+			return o, xerrors.Errorf("%w: format must be jpeg, png or gif, got %q", ErrInvalidOptions, v)
+		}
+	}
+
+	if v := q.Get("q"); v != "" {
+		o.Quality, err = strconv.Atoi(v)
+		if err != nil || o.Quality < 1 || o.Quality > 100 {
+			return o, xerrors.Errorf("%w: q must be a number from 1 to 100, got %q", ErrInvalidOptions, v)
+		}
+	}
+	return o, nil
+}
+
+func dimension(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	d, err := strconv.Atoi(v)
+	if err != nil || d < 1 || d > MaxDimension {
+		return 0, xerrors.Errorf("%w: %s must be a number from 1 to %d, got %q", ErrInvalidOptions, name, MaxDimension, v)
+	}
+	return d, nil
+}
+
+// Key returns a name for the variant which is the same for equivalent options,
+// e.g. 200x200-cover-q80.jpeg, format is the format of the variant
+func (o Options) Key(format string) string {
+	k := fmt.Sprintf("%dx%d", o.Width, o.Height)
+	if o.Width > 0 && o.Height > 0 {
+		k += "-" + o.Fit
+	}
+	if format == "jpeg" {
+		k += fmt.Sprintf("-q%d", o.Quality)
+	}
+	return k + "." + format
+}
+
+// OutputFormat returns the format of the variant of an image in the given format,
+// WebP images are converted to png as there is no pure Go WebP encoder
+func (o Options) OutputFormat(source string) string {
+	if o.Format != "" {
+		return o.Format
+	}
+	switch source {
+	case "jpeg", "png", "gif":
+		return source
+	}
+	return "png"
+}
+
+// SourceFormat returns the format of an image from its file name, e.g. jpeg for hansa.jpg
+func SourceFormat(filename string) string {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if ext == "jpg" {
+		return "jpeg"
+	}
+	return ext
+}
+
+// Resize decodes the image in r and encodes the variant described by o in the given format
+func Resize(r io.Reader, o Options, format string) ([]byte, error) {
+	// decoding the header first rejects huge images before allocating them
+	var buf bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to decode image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, xerrors.Errorf("Image of %dx%d pixels is too large to resize", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to decode image: %w", err)
+	}
+
+	dst := scale(src, o)
+
+	out := &bytes.Buffer{}
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(out, flatten(dst), &jpeg.Options{Quality: o.Quality})
+	case "png":
+		err = png.Encode(out, dst)
+	case "gif":
+		err = gif.Encode(out, dst, nil)
+	default:
+		return nil, xerrors.Errorf("%w: unsupported format %q", ErrInvalidOptions, format)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("Unable to encode image: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// scale returns src resized as described by o
+func scale(src image.Image, o Options) image.Image {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	w, h := o.Width, o.Height
+
+	switch {
+	case w == 0 && h == 0:
+		return src
+	case w == 0:
+		w = max(1, sw*h/sh)
+	case h == 0:
+		h = max(1, sh*w/sw)
+	case o.Fit == FitContain:
+		// the largest size with the aspect ratio of src inside w x h
+		if sw*h > sh*w {
+			h = max(1, sh*w/sw)
+		} else {
+			w = max(1, sw*h/sh)
+		}
+	case o.Fit == FitCover:
+		// crop src to the aspect ratio of w x h around its center, then scale
+		cw, ch := sw, sh
+		if sw*h > sh*w {
+			cw = sh * w / h
+		} else {
+			ch = sw * h / w
+		}
+		x0 := sb.Min.X + (sw-cw)/2
+		y0 := sb.Min.Y + (sh-ch)/2
+		sb = image.Rect(x0, y0, x0+cw, y0+ch)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, sb, draw.Src, nil)
+	return dst
+}
+
+// flatten draws img on a white background, jpeg has no transparency
+func flatten(img image.Image) image.Image {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+package images
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPNG returns a w x h png image
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseOptions(t *testing.T) {
+	q, _ := url.ParseQuery("w=200&h=100&fit=cover&format=jpg&q=80")
+	o, err := ParseOptions(q)
+	assert.NoError(t, err)
+	assert.Equal(t, Options{Width: 200, Height: 100, Fit: FitCover, Format: "jpeg", Quality: 80}, o)
+	assert.Equal(t, "200x100-cover-q80.jpeg", o.Key(o.OutputFormat("png")))
+
+	o, err = ParseOptions(url.Values{"w": {"200"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "200x0.png", o.Key(o.OutputFormat("png")))
+	assert.Equal(t, "200x0.png", o.Key(o.OutputFormat("webp")))
+
+	for _, bad := range []string{"w=0", "w=abc", "h=4001", "fit=stretch", "format=webp", "q=0", "q=101"} {
+		q, _ := url.ParseQuery(bad)
+		_, err := ParseOptions(q)
+		assert.ErrorIs(t, err, ErrInvalidOptions, bad)
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := testPNG(t, 400, 200)
+
+	tests := []struct {
+		o    Options
+		w, h int
+	}{
+		{Options{Width: 100, Fit: FitContain}, 100, 50},
+		{Options{Height: 100, Fit: FitContain}, 200, 100},
+		{Options{Width: 100, Height: 100, Fit: FitContain}, 100, 50},
+		{Options{Width: 100, Height: 100, Fit: FitCover}, 100, 100},
+		{Options{Width: 100, Height: 100, Fit: FitFill}, 100, 100},
+		{Options{Fit: FitContain}, 400, 200},
+	}
+	for _, tc := range tests {
+		d, err := Resize(bytes.NewReader(src), tc.o, "png")
+		assert.NoError(t, err)
+		cfg, err := png.DecodeConfig(bytes.NewReader(d))
+		assert.NoError(t, err)
+		assert.Equal(t, []int{tc.w, tc.h}, []int{cfg.Width, cfg.Height}, "%+v", tc.o)
+	}
+}
+
+func TestResizeConvertsFormat(t *testing.T) {
+	for _, format := range []string{"jpeg", "gif"} {
+		d, err := Resize(bytes.NewReader(testPNG(t, 40, 20)), Options{Width: 20, Quality: 80}, format)
+		assert.NoError(t, err)
+		_, f, err := image.DecodeConfig(bytes.NewReader(d))
+		assert.NoError(t, err)
+		assert.Equal(t, format, f)
+	}
+
+	_, err := Resize(bytes.NewReader([]byte("Hello World")), Options{Width: 20}, "png")
+	assert.Error(t, err)
+}
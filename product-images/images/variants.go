@@ -0,0 +1,322 @@
+package images
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/product-images/files"
+	"golang.org/x/xerrors"
+)
+
+// Variant is a named size of the images, e.g. thumb for 150x150 covered thumbnails
+type Variant struct {
+	Name string
+	Options
+}
+
+var variantPattern = regexp.MustCompile(`^([a-z]+)=(\d*)x(\d*)(?:-(contain|cover|fill))?$`)
+
+// ParseVariants parses a comma separated list of name=WIDTHxHEIGHT[-fit], such as
+// thumb=150x150-cover,medium=600x,large=1200x1200 where an empty dimension keeps the aspect ratio
+func ParseVariants(s string) ([]Variant, error) {
+	vs := []Variant{}
+	seen := map[string]bool{}
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		m := variantPattern.FindStringSubmatch(spec)
+		if m == nil {
+			return nil, xerrors.Errorf("invalid variant %q, expected name=WIDTHxHEIGHT[-fit] such as thumb=150x150-cover", spec)
+		}
+		if seen[m[1]] {
+			return nil, xerrors.Errorf("variant %s is defined more than once", m[1])
+		}
+		seen[m[1]] = true
+
+		v := Variant{Name: m[1], Options: Options{Fit: FitContain, Quality: DefaultQuality}}
+		v.Width, _ = strconv.Atoi(m[2])
+		v.Height, _ = strconv.Atoi(m[3])
+		if m[4] != "" {
+			v.Fit = m[4]
+		}
+		if v.Width > MaxDimension || v.Height > MaxDimension || (v.Width == 0 && v.Height == 0) {
+			return nil, xerrors.Errorf("invalid variant %q, dimensions must be from 1 to %d", spec, MaxDimension)
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// variantsDir is the directory of the Storage variants are saved in,
+// as variants/{id}/{variant}/{filename} so they are not listed with the originals
+const variantsDir = "variants"
+
+// VariantPath returns the path of a variant of a product's image in the Storage
+func VariantPath(id, variant, filename string) string {
+	return filepath.ToSlash(filepath.Join(variantsDir, id, variant, filename))
+}
+
+// Job is the generation of a variant of an image
+type Job struct {
+	ID       string    `json:"id"`
+	Filename string    `json:"filename"`
+	Variant  string    `json:"variant"`
+	Queued   time.Time `json:"queued"`
+	// Error is set for failed jobs
+	Error string `json:"error,omitempty"`
+}
+
+func (j Job) key() string {
+	return j.ID + "/" + j.Filename + "/" + j.Variant
+}
+
+// VariantStatus lists the jobs which are waiting or running and the jobs which failed
+type VariantStatus struct {
+	Pending []Job `json:"pending"`
+	Failed  []Job `json:"failed"`
+}
+
+// Variants generates the variants of uploaded images in the background with a fixed
+// number of workers. Jobs wait in a bounded queue, when it is full new jobs fail
+// rather than blocking the upload.
+type Variants struct {
+	log      hclog.Logger
+	store    files.Storage
+	variants map[string]Variant
+	jobs     chan Job
+
+	// mu guards queued, running and failed, keyed by Job.key, and stopped
+	mu      sync.Mutex
+	queued  map[string]Job
+	running map[string]Job
+	failed  map[string]Job
+	// stopped is set when the queue is closed, jobs are no longer queued then
+	stopped bool
+
+	// saving makes the last check of the original and the save of a variant
+	// atomic with Remove, so a variant of a deleted image is never left behind
+	saving sync.Mutex
+
+	workers int
+	wg      sync.WaitGroup
+}
+
+// NewVariants creates Variants generating vs with workers goroutines and
+// up to queueSize jobs waiting, Start must be called to start the workers
+func NewVariants(l hclog.Logger, store files.Storage, vs []Variant, workers, queueSize int) *Variants {
+	v := &Variants{
+		log:      l,
+		store:    store,
+		variants: map[string]Variant{},
+		jobs:     make(chan Job, queueSize),
+		queued:   map[string]Job{},
+		running:  map[string]Job{},
+		failed:   map[string]Job{},
+		workers:  workers,
+	}
+	for _, vv := range vs {
+		v.variants[vv.Name] = vv
+	}
+	return v
+}
+
+// Start starts the workers, they run until Stop is called
+func (v *Variants) Start() {
+	v.wg.Add(v.workers)
+	for i := 0; i < v.workers; i++ {
+		go v.work()
+	}
+}
+
+// Stop waits for the queued jobs to finish and stops the workers,
+// Generate does nothing afterwards
+func (v *Variants) Stop() {
+	v.mu.Lock()
+	if !v.stopped {
+		v.stopped = true
+		close(v.jobs)
+	}
+	v.mu.Unlock()
+	v.wg.Wait()
+}
+
+// Has returns true when variant is one of the configured variants
+func (v *Variants) Has(variant string) bool {
+	_, ok := v.variants[variant]
+	return ok
+}
+
+// Format returns the format variants of filename are saved in
+func (v *Variants) Format(variant, filename string) string {
+	return v.variants[variant].OutputFormat(SourceFormat(filename))
+}
+
+// Generate queues the generation of every variant of a product's image, a variant
+// which is already waiting is not queued twice as the job reads the image when it runs
+func (v *Variants) Generate(id, filename string) {
+	names := make([]string, 0, len(v.variants))
+	for n := range v.variants {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stopped {
+		// an upload finishing during shutdown, the process exits without its variants
+		v.log.Warn("Variants are stopped, not queuing jobs", "id", id, "filename", filename)
+		return
+	}
+	for _, n := range names {
+		j := Job{ID: id, Filename: filename, Variant: n, Queued: time.Now()}
+		if _, ok := v.queued[j.key()]; ok {
+			continue
+		}
+		delete(v.failed, j.key())
+
+		select {
+		case v.jobs <- j:
+			v.queued[j.key()] = j
+		default:
+			j.Error = "the queue of variant jobs is full"
+			v.failed[j.key()] = j
+			v.log.Error("Unable to queue variant job", "id", id, "filename", filename, "variant", n, "error", j.Error)
+		}
+	}
+}
+
+// Pending returns true when a variant of a product's image is waiting or being generated
+func (v *Variants) Pending(id, variant, filename string) bool {
+	k := Job{ID: id, Filename: filename, Variant: variant}.key()
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	_, queued := v.queued[k]
+	_, running := v.running[k]
+	return queued || running
+}
+
+// Remove deletes the variants of a product's image and forgets its failed jobs,
+// after the image is deleted
+func (v *Variants) Remove(id, filename string) {
+	v.mu.Lock()
+	for n := range v.variants {
+		delete(v.failed, Job{ID: id, Filename: filename, Variant: n}.key())
+	}
+	v.mu.Unlock()
+
+	v.saving.Lock()
+	defer v.saving.Unlock()
+	for n := range v.variants {
+		err := v.store.Delete(VariantPath(id, n, filename))
+		if err != nil && !xerrors.Is(err, files.ErrNotFound) {
+			v.log.Error("Unable to delete variant", "id", id, "filename", filename, "variant", n, "error", err)
+		}
+	}
+}
+
+// Status returns the pending and failed jobs, oldest first
+func (v *Variants) Status() VariantStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s := VariantStatus{Pending: []Job{}, Failed: []Job{}}
+	for _, j := range v.running {
+		s.Pending = append(s.Pending, j)
+	}
+	for _, j := range v.queued {
+		s.Pending = append(s.Pending, j)
+	}
+	for _, j := range v.failed {
+		s.Failed = append(s.Failed, j)
+	}
+	sortJobs(s.Pending)
+	sortJobs(s.Failed)
+	return s
+}
+
+func sortJobs(js []Job) {
+	sort.Slice(js, func(i, k int) bool {
+		if !js[i].Queued.Equal(js[k].Queued) {
+			return js[i].Queued.Before(js[k].Queued)
+		}
+		return js[i].key() < js[k].key()
+	})
+}
+
+// work runs jobs until the queue is closed
+func (v *Variants) work() {
+	defer v.wg.Done()
+	for j := range v.jobs {
+		v.mu.Lock()
+		delete(v.queued, j.key())
+		v.running[j.key()] = j
+		v.mu.Unlock()
+
+		err := v.generate(j)
+
+		v.mu.Lock()
+		delete(v.running, j.key())
+		if err != nil {
+			j.Error = err.Error()
+			v.failed[j.key()] = j
+		}
+		v.mu.Unlock()
+
+		if err != nil {
+			v.log.Error("Unable to generate variant", "id", j.ID, "filename", j.Filename, "variant", j.Variant, "error", err)
+			continue
+		}
+		v.log.Debug("Generated variant", "id", j.ID, "filename", j.Filename, "variant", j.Variant)
+	}
+}
+
+// generate resizes the image of a job and saves the variant. The variant is discarded
+// when the image is deleted or replaced meanwhile, a replaced image has a job of its own.
+func (v *Variants) generate(j Job) error {
+	vv := v.variants[j.Variant]
+	path := filepath.Join(j.ID, j.Filename)
+	before, err := v.store.Stat(path)
+	if xerrors.Is(err, files.ErrNotFound) {
+		v.log.Debug("Image deleted before its variant was generated", "id", j.ID, "filename", j.Filename, "variant", j.Variant)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rc, err := v.store.Get(path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	d, err := Resize(rc, vv.Options, v.Format(j.Variant, j.Filename))
+	if err != nil {
+		return err
+	}
+
+	v.saving.Lock()
+	defer v.saving.Unlock()
+	after, err := v.store.Stat(path)
+	if err != nil && !xerrors.Is(err, files.ErrNotFound) {
+		return err
+	}
+	if err != nil || !after.ModTime.Equal(before.ModTime) || after.Size != before.Size || after.Digest != before.Digest {
+		v.log.Debug("Discarding variant of a changed image", "id", j.ID, "filename", j.Filename, "variant", j.Variant)
+		return nil
+	}
+	err = v.store.Save(VariantPath(j.ID, j.Variant, j.Filename), bytes.NewReader(d))
+	if err != nil {
+		return xerrors.Errorf("Unable to save variant: %w", err)
+	}
+	return nil
+}
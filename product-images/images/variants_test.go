@@ -0,0 +1,146 @@
+package images
+
+import (
+	"bytes"
+	"image/png"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/satoshi-u/go-microservices/product-images/files"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVariants(t *testing.T) {
+	vs, err := ParseVariants("thumb=150x150-cover, medium=600x")
+	assert.NoError(t, err)
+	assert.Equal(t, []Variant{
+		{Name: "thumb", Options: Options{Width: 150, Height: 150, Fit: FitCover, Quality: DefaultQuality}},
+		{Name: "medium", Options: Options{Width: 600, Fit: FitContain, Quality: DefaultQuality}},
+	}, vs)
+
+	for _, bad := range []string{"thumb", "thumb=x", "thumb=10x10-stretch", "Thumb=10x10", "a=10x,a=20x", "big=5000x"} {
+		_, err := ParseVariants(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// waitForJobs waits until the variant jobs have finished
+func waitForJobs(t *testing.T, v *Variants) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(v.Status().Pending) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for variant jobs")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestVariantsGenerate(t *testing.T) {
+	store := files.NewMemory()
+	vs, _ := ParseVariants("thumb=50x50-cover,medium=100x")
+	v := NewVariants(hclog.NewNullLogger(), store, vs, 2, 10)
+	v.Start()
+	defer v.Stop()
+
+	assert.NoError(t, store.Save("1/hansa.png", bytes.NewReader(testPNG(t, 200, 100))))
+	v.Generate("1", "hansa.png")
+	// the original of product 2 is not an image so its jobs fail
+	assert.NoError(t, store.Save("2/hansa.png", bytes.NewBufferString("not an image")))
+	v.Generate("2", "hansa.png")
+	waitForJobs(t, v)
+
+	for path, size := range map[string][]int{"variants/1/thumb/hansa.png": {50, 50}, "variants/1/medium/hansa.png": {100, 50}} {
+		rc, err := store.Get(path)
+		if !assert.NoError(t, err, path) {
+			continue
+		}
+		cfg, err := png.DecodeConfig(rc)
+		assert.NoError(t, err)
+		assert.Equal(t, size, []int{cfg.Width, cfg.Height}, path)
+	}
+
+	s := v.Status()
+	assert.Empty(t, s.Pending)
+	assert.Len(t, s.Failed, 2)
+	assert.Equal(t, "2", s.Failed[0].ID)
+	assert.NotEmpty(t, s.Failed[0].Error)
+
+	v.Remove("1", "hansa.png")
+	fis, _ := store.List("variants/")
+	assert.Empty(t, fis)
+
+	// the failed jobs of a deleted image are forgotten
+	v.Remove("2", "hansa.png")
+	assert.Empty(t, v.Status().Failed)
+}
+
+// changingStore runs onGet after the original is read by a job, as an upload or
+// a delete happening while the variant is generated would
+type changingStore struct {
+	files.Storage
+	onGet func()
+}
+
+func (c *changingStore) Get(path string) (io.ReadCloser, error) {
+	rc, err := c.Storage.Get(path)
+	if c.onGet != nil {
+		c.onGet()
+	}
+	return rc, err
+}
+
+func TestVariantsDiscardStaleResults(t *testing.T) {
+	mem := files.NewMemory()
+	store := &changingStore{Storage: mem}
+	vs, _ := ParseVariants("thumb=50x50-cover")
+	v := NewVariants(hclog.NewNullLogger(), store, vs, 1, 10)
+	v.Start()
+	defer v.Stop()
+
+	// the image is deleted while its variant is generated
+	assert.NoError(t, mem.Save("1/hansa.png", bytes.NewReader(testPNG(t, 200, 100))))
+	store.onGet = func() { mem.Delete("1/hansa.png") }
+	v.Generate("1", "hansa.png")
+	waitForJobs(t, v)
+	_, err := mem.Stat("variants/1/thumb/hansa.png")
+	assert.ErrorIs(t, err, files.ErrNotFound)
+	assert.Empty(t, v.Status().Failed)
+
+	// the image is replaced while its variant is generated, the upload queues a job of its own
+	assert.NoError(t, mem.Save("1/hansa.png", bytes.NewReader(testPNG(t, 200, 100))))
+	store.onGet = func() {
+		store.onGet = nil
+		mem.Save("1/hansa.png", bytes.NewReader(testPNG(t, 100, 300)))
+	}
+	v.Generate("1", "hansa.png")
+	waitForJobs(t, v)
+	_, err = mem.Stat("variants/1/thumb/hansa.png")
+	assert.ErrorIs(t, err, files.ErrNotFound)
+}
+
+func TestVariantsQueueFull(t *testing.T) {
+	vs, _ := ParseVariants("thumb=50x50,medium=100x")
+	// the workers are not started so the queue of one job fills up
+	v := NewVariants(hclog.NewNullLogger(), files.NewMemory(), vs, 1, 1)
+
+	v.Generate("1", "hansa.png")
+	s := v.Status()
+	assert.Len(t, s.Pending, 1)
+	assert.Len(t, s.Failed, 1)
+	assert.True(t, v.Pending("1", "medium", "hansa.png"))
+	assert.Contains(t, s.Failed[0].Error, "full")
+}
+
+func TestVariantsGenerateAfterStop(t *testing.T) {
+	vs, _ := ParseVariants("thumb=50x50")
+	v := NewVariants(hclog.NewNullLogger(), files.NewMemory(), vs, 1, 1)
+	v.Start()
+	v.Stop()
+
+	// uploads finishing during shutdown must not send on the closed queue
+	assert.NotPanics(t, func() { v.Generate("1", "hansa.png") })
+	assert.False(t, v.Pending("1", "thumb", "hansa.png"))
+	v.Stop()
+}
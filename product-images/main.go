@@ -16,11 +16,26 @@ import (
 	"github.com/nicholasjackson/env"
 	"github.com/satoshi-u/go-microservices/product-images/files"
 	"github.com/satoshi-u/go-microservices/product-images/handlers"
+	"github.com/satoshi-u/go-microservices/product-images/images"
 )
 
 var bindAddress = env.String("BIND_ADDRESS", false, ":9091", "Bind address for the server")
 var logLevelDebug = env.String("LOG_LEVEL", false, "debug", "Log output level for the server [debug, info, trace]")
 var basePath = env.String("BASE_PATH", false, "./imagestore", "Base path to save images")
+var maxFileSize = env.Int("MAX_FILE_SIZE", false, 1024*1000*5, "Max size of uploaded images in bytes")
+var storageBackend = env.String("STORAGE", false, "local", "Storage for the images: local, memory or s3")
+var s3Endpoint = env.String("S3_ENDPOINT", false, "http://localhost:9000", "URL of the S3-compatible object store")
+var s3Region = env.String("S3_REGION", false, "us-east-1", "Region of the S3 bucket")
+var s3Bucket = env.String("S3_BUCKET", false, "product-images", "Bucket to save images to")
+var s3AccessKey = env.String("S3_ACCESS_KEY", false, "", "Access key used to sign S3 requests")
+var s3SecretKey = env.String("S3_SECRET_KEY", false, "", "Secret key used to sign S3 requests")
+var s3PathStyle = env.Bool("S3_PATH_STYLE", false, true, "Address the bucket in the path rather than the host name, needed for MinIO")
+var variants = env.String("VARIANTS", false, "thumb=150x150-cover,medium=600x600,large=1200x1200", "Variants generated for uploaded images as name=WIDTHxHEIGHT[-fit], comma separated")
+var variantWorkers = env.Int("VARIANT_WORKERS", false, 2, "Number of variants generated at the same time")
+var blobGCInterval = env.Duration("BLOB_GC_INTERVAL", false, 10*time.Minute, "Interval between removals of the blobs no longer used by any image")
+var cacheMaxAge = env.Duration("CACHE_MAX_AGE", false, time.Hour, "How long clients may cache images before revalidating them, 0 to always revalidate")
+var resizeLimit = env.Int("RESIZE_CONCURRENCY", false, 4, "Images resized on request at the same time, other resize requests wait")
+var variantQueueSize = env.Int("VARIANT_QUEUE_SIZE", false, 100, "Variant jobs waiting for a worker, uploads beyond it fail to queue their variants")
 
 func main() {
 
@@ -36,16 +51,47 @@ func main() {
 	// create a logger for the server from the default logger
 	sl := l.StandardLogger(&hclog.StandardLoggerOptions{InferLevels: true})
 
-	// create the storage class, use local storage
-	// max filesize 5MB
-	stor, err := files.NewLocal(*basePath, 1024*1000*5)
+	// create the storage class, local disk unless configured
+	backend, err := storage()
 	if err != nil {
 		l.Error("Unable to create storage", "error", err)
 		os.Exit(1)
 	}
 
+	// images are saved once per contents whichever products they are uploaded for,
+	// only one instance may use the storage as it holds the index in memory
+	stor, err := files.NewContentAddressed(backend)
+	if err != nil {
+		l.Error("Unable to create storage", "error", err)
+		os.Exit(1)
+	}
+	// images saved before the storage was content addressed are moved into it
+	n, err := stor.Import()
+	if err != nil {
+		l.Error("Unable to import existing images", "error", err)
+		os.Exit(1)
+	}
+	if n > 0 {
+		l.Info("Imported existing images", "files", n)
+	}
+	gcDone := make(chan struct{})
+	gcStopped := make(chan struct{})
+	go func() {
+		defer close(gcStopped)
+		collectBlobs(l.Named("gc"), stor, *blobGCInterval, gcDone)
+	}()
+
+	// named variants of the images are generated in the background after uploads
+	vs, err := images.ParseVariants(*variants)
+	if err != nil {
+		l.Error("Unable to configure variants", "error", err)
+		os.Exit(1)
+	}
+	vg := images.NewVariants(l.Named("variants"), stor, vs, *variantWorkers, *variantQueueSize)
+	vg.Start()
+
 	// create the files handler & gzip middleware
-	filesHandler := handlers.NewFiles(stor, l)
+	filesHandler := handlers.NewFiles(stor, int64(*maxFileSize), l).WithVariants(vg).WithMaxAge(*cacheMaxAge).WithResizeLimit(*resizeLimit)
 	mw := handlers.GzipHandler{}
 
 	// create a new serve mux and register the handlers
@@ -53,26 +99,41 @@ func main() {
 
 	// post files
 	// POST : curl -H 'Content-Type: image/png' localhost:9091/images/1/hansa.png --data-binary @hansa.png
-	// filename regex: {filename:[a-zA-Z]+\\.[a-z]{3}}
+	// filename regex: {filename:[a-zA-Z]+\\.[a-z]{3,4}}
 	// problem with FileServer is that it is dumb
 	postR := sm.Methods(http.MethodPost).Subrouter()
-	postR.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3}}", filesHandler.UploadREST)
+	postR.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", filesHandler.UploadREST)
 	// no curl, use frontend admin section
 	postR.HandleFunc("/", filesHandler.UploadMultipart)
 
-	// get files
-	// GET      : curl -v localhost:9091/images/1/hansa.png -o out.png
-	// GZip GET : curl -v localhost:9091/images/1/hansa.png --compressed -o out_zip.png
-	getR := sm.Methods(http.MethodGet).Subrouter()
-	getR.Handle(
-		"/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3}}",
-		http.StripPrefix("/images/", http.FileServer(http.Dir(*basePath))),
-	)
+	// get files, served from the storage so every backend works the same
+	// GET         : curl -v localhost:9091/images/1/hansa.png -o out.png
+	// HEAD        : curl -I localhost:9091/images/1/hansa.png
+	// Conditional : curl -v localhost:9091/images/1/hansa.png -H 'If-None-Match: "<etag>"'
+	// Range       : curl -v localhost:9091/images/1/hansa.png -r 0-99 -o part.png
+	// GZip GET    : curl -v localhost:9091/images/1 --compressed, images are already compressed so never gzipped
+	// Resized     : curl -v "localhost:9091/images/1/hansa.png?w=200&h=200&fit=cover&format=jpeg&q=80" -o thumb.jpeg
+	getR := sm.Methods(http.MethodGet, http.MethodHead).Subrouter()
+	getR.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", filesHandler.ServeFile)
+	// list files : curl localhost:9091/images/1
+	getR.HandleFunc("/images/{id:[0-9]+}", filesHandler.ListFiles)
+	// variants : curl -v localhost:9091/images/1/thumb/hansa.png -o thumb.png
+	getR.HandleFunc("/images/{id:[0-9]+}/{variant:[a-z]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", filesHandler.ServeVariant)
+	// variant jobs : curl localhost:9091/variants/status
+	getR.HandleFunc("/variants/status", filesHandler.VariantStatus)
 	// gzip middleware
 	getR.Use(mw.GzipMiddleware)
 
+	// delete files
+	// DELETE : curl -v -X DELETE localhost:9091/images/1/hansa.png
+	deleteR := sm.Methods(http.MethodDelete).Subrouter()
+	deleteR.HandleFunc("/images/{id:[0-9]+}/{filename:[a-zA-Z]+\\.[a-z]{3,4}}", filesHandler.DeleteFile)
+
 	// CORS
-	cors := gorHandlers.CORS(gorHandlers.AllowedOrigins([]string{"http://localhost:3000"})) // "http://localhost:3000"   *
+	cors := gorHandlers.CORS(
+		gorHandlers.AllowedOrigins([]string{"http://localhost:3000"}), // "http://localhost:3000"   *
+		gorHandlers.AllowedMethods([]string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete}),
+	)
 
 	// create a new server
 	s := http.Server{
@@ -104,19 +165,65 @@ func main() {
 	sig := <-c
 	l.Info("Shutting down server with", "signal", sig)
 
-	// Empty imagestore for next run
-	l.Info("Emptying ./imagestore")
-	// os.RemoveAll(*basePath + "/")
-	err = RemoveContents(*basePath)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-
 	// gracefully shutdown the server, waiting max 30 seconds for current operations to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	s.Shutdown(ctx)
+	// finish the queued variants of the last uploads, then wait for a running collection
+	vg.Stop()
+	close(gcDone)
+	<-gcStopped
+
+	// Empty imagestore for next run once nothing writes to it, other storages keep their images
+	if *storageBackend == "local" {
+		l.Info("Emptying ./imagestore")
+		// os.RemoveAll(*basePath + "/")
+		err = RemoveContents(*basePath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+// collectBlobs removes the blobs no longer used by any image every interval until done is closed
+func collectBlobs(l hclog.Logger, stor *files.ContentAddressed, interval time.Duration, done chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			n, err := stor.GC()
+			if err != nil {
+				l.Error("Unable to remove unused blobs", "error", err)
+				continue
+			}
+			l.Debug("Removed unused blobs", "count", n)
+		}
+	}
+}
+
+// storage creates the Storage selected by the STORAGE env var
+func storage() (files.Storage, error) {
+	switch *storageBackend {
+	case "local":
+		// the index of the images is saved in the storage too, without the max file size
+		return files.NewLocal(*basePath, *maxFileSize)
+	case "memory":
+		return files.NewMemory(), nil
+	case "s3":
+		return files.NewS3(files.S3Config{
+			Endpoint:  *s3Endpoint,
+			Region:    *s3Region,
+			Bucket:    *s3Bucket,
+			AccessKey: *s3AccessKey,
+			SecretKey: *s3SecretKey,
+			PathStyle: *s3PathStyle,
+		}, &http.Client{Timeout: 30 * time.Second})
+	}
+	return nil, fmt.Errorf("unknown storage %q, expected local, memory or s3", *storageBackend)
 }
 
 // RemoveContents : empties all content of a dir
@@ -0,0 +1,109 @@
+// Package webhook posts JSON bodies to webhooks signed with HMAC-SHA256, it is
+// shared by the rate alerts and the product-api events so receivers verify both the same way
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader is the header containing the HMAC-SHA256 of the webhook body
+const SignatureHeader = "X-Signature-256"
+
+// Client posts signed JSON bodies, retrying failed deliveries with a doubling backoff
+type Client struct {
+	secret     []byte
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+// NewClient creates a Client signing with secret
+func NewClient(secret string) *Client {
+	return &Client{
+		secret:     []byte(secret),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			// a redirect could send the signed body to a host the URL was not checked against
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxRetries: 3,
+		backoff:    500 * time.Millisecond,
+	}
+}
+
+// WithRetries sets the number of retries and the initial backoff between them,
+// the backoff doubles after every failed attempt
+func (c *Client) WithRetries(maxRetries int, backoff time.Duration) *Client {
+	c.maxRetries = maxRetries
+	c.backoff = backoff
+	return c
+}
+
+// Post posts the JSON body to url with the extra headers, retrying on network errors, 429 and 5xx responses
+func (c *Client) Post(ctx context.Context, url string, header http.Header, body []byte) error {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.post(ctx, url, header, body)
+		if err == nil || !retry || attempt >= c.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes a single delivery attempt and reports if a failure is worth retrying
+func (c *Client) post(ctx context.Context, url string, header http.Header, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(c.secret, body))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned status_code %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("webhook rejected the request with status_code %d", resp.StatusCode)
+	}
+}
+
+// Sign returns the value of the SignatureHeader for the given body
+func Sign(secret, body []byte) string {
+	m := hmac.New(sha256.New, secret)
+	m.Write(body)
+	return "sha256=" + hex.EncodeToString(m.Sum(nil))
+}
+
+// VerifySignature checks the value of a SignatureHeader against the body
+func VerifySignature(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostSignsAndRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.True(t, VerifySignature([]byte("s3cret"), body, r.Header.Get(SignatureHeader)))
+		assert.Equal(t, "42", r.Header.Get("X-Event-ID"))
+		// fail the first attempt to force a retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	c := NewClient("s3cret").WithRetries(2, time.Millisecond)
+	err := c.Post(context.Background(), ts.URL, http.Header{"X-Event-Id": {"42"}}, []byte(`{"id":42}`))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestPostDoesNotRetryRejections(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		rw.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	c := NewClient("s3cret").WithRetries(2, time.Millisecond)
+	assert.Error(t, c.Post(context.Background(), ts.URL, nil, []byte(`{}`)))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"id":42}`)
+	sig := Sign([]byte("s3cret"), body)
+	assert.True(t, VerifySignature([]byte("s3cret"), body, sig))
+	assert.False(t, VerifySignature([]byte("other"), body, sig))
+	assert.False(t, VerifySignature([]byte("s3cret"), []byte(`{"id":43}`), sig))
+}
+
+func TestPostDoesNotFollowRedirects(t *testing.T) {
+	var calls int32
+	other := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer other.Close()
+	ts := httptest.NewServer(http.RedirectHandler(other.URL, http.StatusTemporaryRedirect))
+	defer ts.Close()
+
+	c := NewClient("s3cret").WithRetries(0, time.Millisecond)
+	assert.Error(t, c.Post(context.Background(), ts.URL, nil, []byte(`{}`)))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}